@@ -0,0 +1,400 @@
+package core
+
+import (
+    "bufio"
+    "context"
+    "crypto/md5"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/hex"
+    "fmt"
+    "hash"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "strings"
+    "sync"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+const (
+    ChecksumMD5    = "md5"
+    ChecksumSHA1   = "sha1"
+    ChecksumSHA256 = "sha256"
+    ChecksumSHA512 = "sha512"
+)
+
+// ChecksumAlgorithms lists the supported algorithms, strongest first
+var ChecksumAlgorithms = []string{ChecksumSHA512, ChecksumSHA256, ChecksumSHA1, ChecksumMD5}
+
+const (
+    checksumProbeTimeout = 10 * time.Second
+    maxChecksumFileSize  = 1 << 20
+    maxChecksumProbes    = 6                // requests per file, cached listings are free
+    checksumListingTTL   = 10 * time.Minute // how long a directory's SUMS listing is reused
+)
+
+// ChecksumError is returned when a finished file does not match its expected digest
+type ChecksumError struct {
+    Algorithm string
+    Expected  string
+    Actual    string
+}
+
+func (e *ChecksumError) Error() string {
+    return fmt.Sprintf("%s checksum mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+    switch algorithm {
+    case ChecksumMD5:
+        return md5.New(), nil
+    case ChecksumSHA1:
+        return sha1.New(), nil
+    case ChecksumSHA256:
+        return sha256.New(), nil
+    case ChecksumSHA512:
+        return sha512.New(), nil
+    default:
+        return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+    }
+}
+
+// NormalizeChecksum validates a hex digest and returns it in lower case
+// together with its algorithm. When algorithm is empty it is guessed from
+// the digest length.
+func NormalizeChecksum(algorithm, digest string) (string, string, error) {
+    digest = strings.ToLower(strings.TrimSpace(digest))
+    algorithm = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(algorithm), "-", ""))
+
+    if algorithm == "" {
+        switch len(digest) {
+        case md5.Size * 2:
+            algorithm = ChecksumMD5
+        case sha1.Size * 2:
+            algorithm = ChecksumSHA1
+        case sha256.Size * 2:
+            algorithm = ChecksumSHA256
+        case sha512.Size * 2:
+            algorithm = ChecksumSHA512
+        default:
+            return "", "", fmt.Errorf("cannot tell the checksum algorithm from a %d character digest", len(digest))
+        }
+    }
+
+    h, err := newHash(algorithm)
+    if err != nil {
+        return "", "", err
+    }
+    if len(digest) != h.Size()*2 {
+        return "", "", fmt.Errorf("a %s digest has %d hex characters, got %d", algorithm, h.Size()*2, len(digest))
+    }
+    if _, err := hex.DecodeString(digest); err != nil {
+        return "", "", fmt.Errorf("checksum is not a hex string")
+    }
+
+    return algorithm, digest, nil
+}
+
+// verifyFile hashes the first size bytes of the file at path. Chunks are
+// written out of order, so the file is read through ReadAt rather than
+// hashed while the data arrives.
+func verifyFile(filePath string, size int64, algorithm, expected string) error {
+    h, err := newHash(algorithm)
+    if err != nil {
+        return err
+    }
+
+    file, err := os.Open(filePath)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    if size <= 0 {
+        info, err := file.Stat()
+        if err != nil {
+            return err
+        }
+        size = info.Size()
+    }
+
+    if _, err := io.Copy(h, io.NewSectionReader(file, 0, size)); err != nil {
+        return err
+    }
+
+    actual := hex.EncodeToString(h.Sum(nil))
+    if actual != expected {
+        return &ChecksumError{Algorithm: algorithm, Expected: expected, Actual: actual}
+    }
+    return nil
+}
+
+// discoverChecksum looks for a published digest next to the download URL,
+// first in a "<ALGORITHM>SUMS" listing of the same directory and then as
+// "<file>.<algorithm>". The listings are remembered per directory, so the
+// files of a package share them, and one file sends at most
+// maxChecksumProbes requests.
+func (dm *DownloadManager) discoverChecksum(ctx context.Context, rawURL, filename string) (string, string) {
+    u, err := url.Parse(rawURL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+        return "", ""
+    }
+    u.RawQuery = ""
+    u.Fragment = ""
+
+    remoteName := path.Base(u.Path)
+    names := []string{filename}
+    if remoteName != filename && remoteName != "/" && remoteName != "." {
+        names = append(names, remoteName)
+    }
+
+    client := dm.newHTTPClient()
+    client.Timeout = checksumProbeTimeout
+    userAgent := dm.Config().UserAgent
+    probes := 0
+
+    dir := *u
+    dir.Path = path.Dir(u.Path)
+    listing := dm.checksumListings.lookup(dir.String(), time.Now(), func() (*checksumListing, bool) {
+        for _, algorithm := range ChecksumAlgorithms {
+            sumsURL := *u
+            sumsURL.Path = path.Join(dir.Path, strings.ToUpper(algorithm)+"SUMS")
+            probes++
+            digests, _, err := fetchChecksumFile(ctx, client, userAgent, sumsURL.String(), algorithm)
+            if err != nil {
+                // Not known to be missing, the next file asks again
+                return nil, false
+            }
+            if digests != nil {
+                return &checksumListing{algorithm: algorithm, digests: digests}, true
+            }
+        }
+        return &checksumListing{}, true
+    })
+    if listing != nil {
+        for _, name := range names {
+            if digest := listing.digests[name]; digest != "" {
+                return listing.algorithm, digest
+            }
+        }
+    }
+
+    for _, algorithm := range ChecksumAlgorithms {
+        if probes >= maxChecksumProbes || ctx.Err() != nil {
+            break
+        }
+        probes++
+
+        sidecarURL := *u
+        sidecarURL.Path += "." + algorithm
+        digests, lines, _ := fetchChecksumFile(ctx, client, userAgent, sidecarURL.String(), algorithm)
+        for _, name := range names {
+            if digest := digests[name]; digest != "" {
+                return algorithm, digest
+            }
+        }
+        // A file of its own may hold just the bare digest
+        if lines == 1 && digests[""] != "" {
+            return algorithm, digests[""]
+        }
+    }
+
+    return "", ""
+}
+
+// lookUpChecksum looks for a published checksum once the transfer is done,
+// so adding a download does not wait for it. It fails only when the job was
+// stopped meanwhile.
+func (dm *DownloadManager) lookUpChecksum(job *DownloadJob) error {
+    download := job.download
+
+    job.mutex.RLock()
+    known := download.Checksum != ""
+    rawURL, filename := download.URL, download.Filename
+    job.mutex.RUnlock()
+    if known || !dm.Config().DiscoverChecksums {
+        return nil
+    }
+
+    algorithm, checksum := dm.discoverChecksum(job.ctx, rawURL, filename)
+    if err := job.ctx.Err(); err != nil {
+        return err
+    }
+    if checksum == "" {
+        return nil
+    }
+
+    job.mutex.Lock()
+    download.ChecksumAlgorithm = algorithm
+    download.Checksum = checksum
+    download.ChecksumSource = storage.ChecksumFromFile
+    job.mutex.Unlock()
+
+    return nil
+}
+
+// checksumListing is the digests of a SUMS listing by file name, empty when
+// the directory has none
+type checksumListing struct {
+    algorithm string
+    digests   map[string]string
+    fetched   time.Time
+    ready     chan struct{} // closed once fetched
+}
+
+// checksumCache remembers the SUMS listings of directories for
+// checksumListingTTL
+type checksumCache struct {
+    mutex    sync.Mutex
+    listings map[string]*checksumListing
+}
+
+func newChecksumCache() *checksumCache {
+    return &checksumCache{listings: make(map[string]*checksumListing)}
+}
+
+// lookup returns the listing of dir, calling fetch unless a recent one is
+// known. Callers asking while another fetches wait for its result. A fetch
+// that reports false is not remembered, and lookup returns nil.
+func (c *checksumCache) lookup(dir string, now time.Time, fetch func() (*checksumListing, bool)) *checksumListing {
+    c.mutex.Lock()
+    if listing, ok := c.listings[dir]; ok {
+        select {
+        case <-listing.ready:
+            if now.Sub(listing.fetched) < checksumListingTTL {
+                c.mutex.Unlock()
+                return listing
+            }
+        default:
+            c.mutex.Unlock()
+            <-listing.ready
+            if listing.fetched.IsZero() {
+                return nil
+            }
+            return listing
+        }
+    }
+    c.prune(now)
+
+    pending := &checksumListing{ready: make(chan struct{})}
+    c.listings[dir] = pending
+    c.mutex.Unlock()
+
+    listing, ok := fetch()
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if ok {
+        pending.algorithm = listing.algorithm
+        pending.digests = listing.digests
+        pending.fetched = now
+    } else {
+        delete(c.listings, dir)
+    }
+    close(pending.ready)
+
+    if !ok {
+        return nil
+    }
+    return pending
+}
+
+// prune drops the expired listings, with the mutex held
+func (c *checksumCache) prune(now time.Time) {
+    for dir, listing := range c.listings {
+        select {
+        case <-listing.ready:
+            if now.Sub(listing.fetched) >= checksumListingTTL {
+                delete(c.listings, dir)
+            }
+        default:
+        }
+    }
+}
+
+// fetchChecksumFile downloads a checksum listing and returns its digests by
+// file name, a bare digest under "", and the number of entries. Without a
+// listing at rawURL the digests are nil; err is only set when the server
+// could not tell.
+func fetchChecksumFile(ctx context.Context, client *http.Client, userAgent, rawURL, algorithm string) (map[string]string, int, error) {
+    req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+    if err != nil {
+        return nil, 0, err
+    }
+    req.Header.Set("User-Agent", userAgent)
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 500 {
+        return nil, 0, newHTTPStatusError(resp)
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, 0, nil
+    }
+
+    digests, lines := parseChecksumList(io.LimitReader(resp.Body, maxChecksumFileSize), algorithm)
+    return digests, lines, nil
+}
+
+// parseChecksumList understands the GNU "<digest>  <name>" (also with
+// "*<name>" for binary mode) and the BSD "SHA256 (<name>) = <digest>"
+// formats. It returns the digests by file name, a digest without a name
+// under "", and the number of entries.
+func parseChecksumList(r io.Reader, algorithm string) (map[string]string, int) {
+    digests := make(map[string]string)
+    var lines int
+
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        lines++
+
+        // The name ends at the last ") = " and starts at the first " ("
+        // before it; lines from a server may have them in any order
+        var digest, name string
+        close := strings.LastIndex(line, ") = ")
+        open := -1
+        if close > 0 {
+            open = strings.Index(line[:close], " (")
+        }
+        if open > 0 {
+            // BSD style
+            name = line[open+2 : close]
+            digest = line[close+4:]
+        } else {
+            fields := strings.Fields(line)
+            digest = fields[0]
+            if len(fields) > 1 {
+                name = strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+            }
+        }
+
+        _, digest, err := NormalizeChecksum(algorithm, digest)
+        if err != nil {
+            continue
+        }
+
+        name = path.Base(strings.TrimPrefix(name, "./"))
+        if name == "." || name == "-" {
+            name = ""
+        }
+        if _, seen := digests[name]; !seen {
+            digests[name] = digest
+        }
+    }
+
+    return digests, lines
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestParseChecksumList(t *testing.T) {
+    const (
+        digestA = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+        digestB = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+    )
+
+    tests := []struct {
+        name    string
+        listing string
+        want    map[string]string
+        lines   int
+    }{
+        {"gnu", digestA + "  a.iso\n" + digestB + "  b.iso\n", map[string]string{"a.iso": digestA, "b.iso": digestB}, 2},
+        {"gnu binary mode", digestA + " *a.iso\n", map[string]string{"a.iso": digestA}, 1},
+        {"gnu with a path", digestA + "  ./dir/a.iso\n", map[string]string{"a.iso": digestA}, 1},
+        {"gnu name with spaces", digestA + "  my file.iso\n", map[string]string{"my file.iso": digestA}, 1},
+        {"bsd", "SHA256 (a.iso) = " + digestA + "\n", map[string]string{"a.iso": digestA}, 1},
+        {"bsd name with parentheses", "SHA256 (a (1).iso) = " + digestA + "\n", map[string]string{"a (1).iso": digestA}, 1},
+        {"bare digest", digestA + "\n", map[string]string{"": digestA}, 1},
+        {"bare digest for stdin", digestA + "  -\n", map[string]string{"": digestA}, 1},
+        {"upper case digest", strings.ToUpper(digestA) + "  a.iso\n", map[string]string{"a.iso": digestA}, 1},
+        {"comments and blank lines", "# SHA256\n\n" + digestA + "  a.iso\n", map[string]string{"a.iso": digestA}, 1},
+        {"first entry wins", digestA + "  a.iso\n" + digestB + "  a.iso\n", map[string]string{"a.iso": digestA}, 2},
+        {"wrong length", "abc123  a.iso\n", map[string]string{}, 1},
+        {"not hex", strings.Repeat("z", 64) + "  a.iso\n", map[string]string{}, 1},
+        {"bsd markers out of order", "x) = y (z\n", map[string]string{}, 1},
+        {"bsd without a name", "SHA256 () = " + digestA + "\n", map[string]string{"": digestA}, 1},
+        {"bsd marker at the start", ") = (" + digestA + "\n", map[string]string{}, 1},
+        {"only a close marker", "a.iso) = " + digestA + "\n", map[string]string{}, 1},
+    }
+
+    for _, test := range tests {
+        got, lines := parseChecksumList(strings.NewReader(test.listing), ChecksumSHA256)
+        if lines != test.lines {
+            t.Errorf("%s: %d lines, want %d", test.name, lines, test.lines)
+        }
+        if len(got) != len(test.want) {
+            t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+            continue
+        }
+        for name, digest := range test.want {
+            if got[name] != digest {
+                t.Errorf("%s: digest of %q is %q, want %q", test.name, name, got[name], digest)
+            }
+        }
+    }
+}
@@ -0,0 +1,87 @@
+package core
+
+// Chunk scheduling. Every worker owns one connection. When a worker runs out
+// of unassigned chunks it steals the second half of the largest range still
+// being fetched, so the number of open connections stays at download.Chunks
+// until the remaining ranges get too small to split.
+
+// nextChunk hands out the next range for a worker, or nil when there is
+// nothing left worth a new connection
+func (job *DownloadJob) nextChunk(minSplit int64) *ChunkDownloader {
+    job.mutex.Lock()
+    defer job.mutex.Unlock()
+
+    if job.aborted {
+        return nil
+    }
+
+    for _, chunk := range job.chunks {
+        if !chunk.active && chunk.remaining() > 0 {
+            chunk.active = true
+            return chunk
+        }
+    }
+
+    var victim *ChunkDownloader
+    var largest int64
+    for _, chunk := range job.chunks {
+        if remaining := chunk.remaining(); chunk.active && remaining > largest {
+            victim, largest = chunk, remaining
+        }
+    }
+    if victim == nil || largest < 2*minSplit {
+        return nil
+    }
+
+    stolen := victim.split()
+    if stolen == nil {
+        return nil
+    }
+    stolen.active = true
+
+    // Keep job.chunks ordered by offset so the persisted layout tiles the file
+    for i, chunk := range job.chunks {
+        if chunk == victim {
+            job.chunks = append(job.chunks[:i+1], append([]*ChunkDownloader{stolen}, job.chunks[i+1:]...)...)
+            break
+        }
+    }
+
+    return stolen
+}
+
+// releaseChunk returns a chunk to the pool. A failed worker also stops the
+// others from picking up new work.
+func (job *DownloadJob) releaseChunk(chunk *ChunkDownloader, err error) {
+    job.mutex.Lock()
+    defer job.mutex.Unlock()
+
+    chunk.active = false
+    if err != nil {
+        job.aborted = true
+    }
+}
+
+// split cuts the not yet downloaded part of the chunk in half. The chunk
+// keeps the first half, its worker stops writing once it reaches the new
+// end; the second half is returned as a new chunk.
+func (c *ChunkDownloader) split() *ChunkDownloader {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    offset := c.start + c.downloaded
+    remaining := c.end - offset + 1
+    if remaining < 2 {
+        return nil
+    }
+
+    mid := offset + remaining/2
+    stolen := &ChunkDownloader{
+        start: mid,
+        end:   c.end,
+        file:  c.file,
+    }
+    c.end = mid - 1
+
+    return stolen
+}
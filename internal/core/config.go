@@ -0,0 +1,221 @@
+package core
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "time"
+)
+
+// Keys of the stored configuration
+const (
+    settingMaxConcurrentDownloads = "max_concurrent_downloads"
+    settingChunkSize              = "chunk_size"
+    settingMaxSpeed               = "max_speed"
+    settingRetryAttempts          = "retry_attempts"
+    settingUserAgent              = "user_agent"
+    settingTimeout                = "timeout"
+    settingConflictPolicy         = "conflict_policy"
+    settingDiscoverChecksums      = "discover_checksums"
+    settingBandwidthSchedule      = "bandwidth_schedule"
+    settingAutoResume             = "auto_resume"
+)
+
+// Config returns a copy of the current configuration. Its BandwidthSchedule
+// is shared and must not be changed; set a new one with UpdateConfig.
+func (dm *DownloadManager) Config() DownloadConfig {
+    dm.configMutex.RLock()
+    defer dm.configMutex.RUnlock()
+
+    return *dm.config
+}
+
+// UpdateConfig validates and saves a new configuration and applies it to
+// the running manager: the concurrency and the speed limits right away,
+// the user agent, timeout, chunk size and retries to the requests that
+// follow. It fails without changing anything when the configuration is
+// invalid, and with the configuration applied when only saving failed.
+func (dm *DownloadManager) UpdateConfig(config DownloadConfig) error {
+    return dm.updateConfig(func(c *DownloadConfig) {
+        *c = config
+    })
+}
+
+// updateConfig changes part of the configuration, see UpdateConfig
+func (dm *DownloadManager) updateConfig(change func(config *DownloadConfig)) error {
+    dm.configMutex.Lock()
+    config := *dm.config
+    change(&config)
+    if err := config.Validate(); err != nil {
+        dm.configMutex.Unlock()
+        return err
+    }
+    settings, err := config.settings()
+    if err != nil {
+        dm.configMutex.Unlock()
+        return err
+    }
+    dm.config = &config
+
+    // Saved under the lock, so that concurrent updates are saved in the
+    // order they were applied
+    err = dm.store.SaveSettings(settings)
+    dm.configMutex.Unlock()
+
+    dm.configChanged()
+
+    if err != nil {
+        return fmt.Errorf("saving settings: %w", err)
+    }
+    return nil
+}
+
+// loadSettings takes the configuration from the store. Settings the store
+// does not have keep their defaults.
+func (dm *DownloadManager) loadSettings() error {
+    settings, err := dm.store.GetSettings()
+    if err != nil {
+        return err
+    }
+
+    config := DefaultConfig()
+    config.applySettings(settings)
+
+    dm.configMutex.Lock()
+    dm.config = config
+    dm.configMutex.Unlock()
+
+    dm.configChanged()
+    return nil
+}
+
+// configChanged applies the speed limits of a new configuration, and wakes
+// runBandwidthSchedule for its schedule
+func (dm *DownloadManager) configChanged() {
+    dm.applyBandwidthLimit()
+
+    select {
+    case dm.scheduleChanged <- struct{}{}:
+    default:
+    }
+}
+
+// Validate reports the first setting out of range
+func (c *DownloadConfig) Validate() error {
+    switch {
+    case c.MaxConcurrentDownloads < 1:
+        return fmt.Errorf("at least one download must be allowed to run")
+    case c.ChunkSize < 1024:
+        return fmt.Errorf("chunk size must be at least 1024 bytes")
+    case c.MaxSpeed < 0:
+        return fmt.Errorf("max speed must not be negative")
+    case c.RetryAttempts < 0:
+        return fmt.Errorf("retry attempts must not be negative")
+    case c.UserAgent == "":
+        return fmt.Errorf("user agent must not be empty")
+    case c.Timeout < time.Second:
+        return fmt.Errorf("timeout must be at least a second")
+    case c.ConflictPolicy == ConflictDefault:
+        return fmt.Errorf("the conflict policy must be a concrete choice")
+    }
+
+    return nil
+}
+
+func (c *DownloadConfig) settings() (map[string]string, error) {
+    schedule := ""
+    if c.BandwidthSchedule != nil {
+        data, err := json.Marshal(c.BandwidthSchedule)
+        if err != nil {
+            return nil, err
+        }
+        schedule = string(data)
+    }
+
+    return map[string]string{
+        settingMaxConcurrentDownloads: strconv.Itoa(c.MaxConcurrentDownloads),
+        settingChunkSize:              strconv.FormatInt(c.ChunkSize, 10),
+        settingMaxSpeed:               strconv.FormatInt(c.MaxSpeed, 10),
+        settingRetryAttempts:          strconv.Itoa(c.RetryAttempts),
+        settingUserAgent:              c.UserAgent,
+        settingTimeout:                c.Timeout.String(),
+        settingConflictPolicy:         strconv.Itoa(int(c.ConflictPolicy)),
+        settingDiscoverChecksums:      strconv.FormatBool(c.DiscoverChecksums),
+        settingBandwidthSchedule:      schedule,
+        settingAutoResume:             strconv.FormatBool(c.AutoResume),
+    }, nil
+}
+
+// applySettings takes over the stored settings. Missing ones and values
+// that do not parse or validate keep what c has.
+func (c *DownloadConfig) applySettings(settings map[string]string) {
+    parsers := map[string]func(value string, config *DownloadConfig) error{
+        settingMaxConcurrentDownloads: func(value string, config *DownloadConfig) (err error) {
+            config.MaxConcurrentDownloads, err = strconv.Atoi(value)
+            return err
+        },
+        settingChunkSize: func(value string, config *DownloadConfig) (err error) {
+            config.ChunkSize, err = strconv.ParseInt(value, 10, 64)
+            return err
+        },
+        settingMaxSpeed: func(value string, config *DownloadConfig) (err error) {
+            config.MaxSpeed, err = strconv.ParseInt(value, 10, 64)
+            return err
+        },
+        settingRetryAttempts: func(value string, config *DownloadConfig) (err error) {
+            config.RetryAttempts, err = strconv.Atoi(value)
+            return err
+        },
+        settingUserAgent: func(value string, config *DownloadConfig) error {
+            config.UserAgent = value
+            return nil
+        },
+        settingTimeout: func(value string, config *DownloadConfig) (err error) {
+            config.Timeout, err = time.ParseDuration(value)
+            return err
+        },
+        settingConflictPolicy: func(value string, config *DownloadConfig) error {
+            policy, err := strconv.Atoi(value)
+            if err != nil {
+                return err
+            }
+            if policy < int(ConflictRename) || policy > int(ConflictResume) {
+                return fmt.Errorf("unknown conflict policy %d", policy)
+            }
+            config.ConflictPolicy = ConflictPolicy(policy)
+            return nil
+        },
+        settingDiscoverChecksums: func(value string, config *DownloadConfig) (err error) {
+            config.DiscoverChecksums, err = strconv.ParseBool(value)
+            return err
+        },
+        settingBandwidthSchedule: func(value string, config *DownloadConfig) error {
+            if value == "" {
+                config.BandwidthSchedule = nil
+                return nil
+            }
+            schedule := &BandwidthSchedule{}
+            if err := json.Unmarshal([]byte(value), schedule); err != nil {
+                return err
+            }
+            config.BandwidthSchedule = schedule
+            return nil
+        },
+        settingAutoResume: func(value string, config *DownloadConfig) (err error) {
+            config.AutoResume, err = strconv.ParseBool(value)
+            return err
+        },
+    }
+
+    for key, parse := range parsers {
+        value, ok := settings[key]
+        if !ok {
+            continue
+        }
+        changed := *c
+        if err := parse(value, &changed); err != nil || changed.Validate() != nil {
+            continue
+        }
+        *c = changed
+    }
+}
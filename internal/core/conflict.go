@@ -0,0 +1,104 @@
+package core
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+// applyConflictPolicy settles the target name of a new download before it is
+// saved. The caller must hold dm.pathMutex until the download is stored, so
+// two downloads added at the same time cannot claim the same file.
+func (dm *DownloadManager) applyConflictPolicy(download *storage.Download) error {
+    if download.Conflict == ConflictDefault {
+        download.Conflict = dm.Config().ConflictPolicy
+    }
+
+    reserved, err := dm.reservedNames(download.Path)
+    if err != nil {
+        return err
+    }
+
+    fullPath := filepath.Join(download.Path, download.Filename)
+    info, statErr := os.Stat(fullPath)
+    exists := statErr == nil
+
+    if !exists && !reserved[download.Filename] {
+        return nil
+    }
+
+    // Another unfinished download already writes this file, whatever the
+    // policy says the only safe choice is a different name.
+    if !reserved[download.Filename] {
+        switch download.Conflict {
+        case ConflictOverwrite:
+            return nil
+        case ConflictSkip:
+            if download.Size > 0 && info.Size() == download.Size {
+                now := time.Now()
+                download.Status = StatusCompleted
+                download.Downloaded = download.Size
+                download.Progress = 100.0
+                download.CompletedAt = &now
+                return nil
+            }
+        case ConflictResume:
+            if info.Mode().IsRegular() && (download.Size == 0 || info.Size() <= download.Size) {
+                return nil
+            }
+        }
+    }
+
+    name, err := uniqueFilename(download.Path, download.Filename, reserved)
+    if err != nil {
+        return err
+    }
+    download.Filename = name
+
+    return nil
+}
+
+// reservedNames returns the file names claimed by unfinished downloads in dir
+func (dm *DownloadManager) reservedNames(dir string) (map[string]bool, error) {
+    downloads, err := dm.store.GetAllDownloads()
+    if err != nil {
+        return nil, err
+    }
+
+    dir = filepath.Clean(dir)
+    reserved := make(map[string]bool)
+    for _, d := range downloads {
+        switch d.Status {
+        case StatusCompleted, StatusCancelled:
+            continue
+        }
+        if filepath.Clean(d.Path) == dir {
+            reserved[d.Filename] = true
+        }
+    }
+
+    return reserved, nil
+}
+
+// uniqueFilename appends " (n)" before the extension until the name is free
+// on disk and not claimed by another download
+func uniqueFilename(dir, name string, reserved map[string]bool) (string, error) {
+    ext := filepath.Ext(name)
+    stem := strings.TrimSuffix(name, ext)
+
+    for i := 1; i < 10000; i++ {
+        candidate := sanitizeFilename(fmt.Sprintf("%s (%d)%s", stem, i, ext))
+        if reserved[candidate] {
+            continue
+        }
+        if _, err := os.Lstat(filepath.Join(dir, candidate)); os.IsNotExist(err) {
+            return candidate, nil
+        }
+    }
+
+    return "", fmt.Errorf("no free file name for %s", name)
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+    "fmt"
+    "strings"
+)
+
+// AddDependency makes a download wait until another one has completed
+func (dm *DownloadManager) AddDependency(downloadID, dependsOn int64) error {
+    if downloadID == dependsOn {
+        return fmt.Errorf("a download cannot wait for itself")
+    }
+
+    download, err := dm.store.GetDownload(downloadID)
+    if err != nil {
+        return err
+    }
+    if _, err := dm.store.GetDownload(dependsOn); err != nil {
+        return err
+    }
+
+    waits, err := dm.waitsFor(dependsOn, downloadID)
+    if err != nil {
+        return err
+    }
+    if waits {
+        return fmt.Errorf("the downloads would wait for each other")
+    }
+
+    if err := dm.store.AddDependency(downloadID, dependsOn); err != nil {
+        return err
+    }
+
+    // Waiting for a download that already failed blocks right away
+    return dm.checkBlocked(download)
+}
+
+// RemoveDependency drops a link added by AddDependency. A download blocked
+// only by that link goes back to its queue.
+func (dm *DownloadManager) RemoveDependency(downloadID, dependsOn int64) error {
+    if err := dm.store.RemoveDependency(downloadID, dependsOn); err != nil {
+        return err
+    }
+
+    download, err := dm.store.GetDownload(downloadID)
+    if err != nil {
+        return err
+    }
+    if download.Status == StatusBlocked {
+        return dm.unblock(download)
+    }
+    return nil
+}
+
+// GetDependencies returns the IDs of the downloads a download waits for
+func (dm *DownloadManager) GetDependencies(downloadID int64) ([]int64, error) {
+    return dm.store.GetDependencies(downloadID)
+}
+
+// Chain makes every download wait for the one before it, so that they run
+// in the given order
+func (dm *DownloadManager) Chain(downloadIDs ...int64) error {
+    for i := 1; i < len(downloadIDs); i++ {
+        if err := dm.AddDependency(downloadIDs[i], downloadIDs[i-1]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// waitsFor reports whether a download waits for target, directly or through
+// other downloads
+func (dm *DownloadManager) waitsFor(downloadID, target int64) (bool, error) {
+    seen := map[int64]bool{downloadID: true}
+    pending := []int64{downloadID}
+
+    for len(pending) > 0 {
+        id := pending[len(pending)-1]
+        pending = pending[:len(pending)-1]
+
+        dependencies, err := dm.store.GetDependencies(id)
+        if err != nil {
+            return false, err
+        }
+        for _, dependency := range dependencies {
+            if dependency == target {
+                return true, nil
+            }
+            if !seen[dependency] {
+                seen[dependency] = true
+                pending = append(pending, dependency)
+            }
+        }
+    }
+
+    return false, nil
+}
+
+// dependencyState tells whether all downloads a download waits for have
+// completed, and returns the first one that failed, if any
+func (dm *DownloadManager) dependencyState(downloadID int64) (bool, *Download) {
+    dependencies, err := dm.store.GetDependencies(downloadID)
+    if err != nil {
+        return false, nil
+    }
+
+    ready := true
+    for _, id := range dependencies {
+        dependency, err := dm.store.GetDownload(id)
+        if err != nil {
+            // A deleted download takes its links with it, so this is a
+            // database error; try again later
+            return false, nil
+        }
+
+        switch {
+        case isFailure(dependency.Status):
+            return false, dependency
+        case dependency.Status != StatusCompleted:
+            ready = false
+        }
+    }
+
+    return ready, nil
+}
+
+// dependenciesMet is the queue filter of processQueue
+func (dm *DownloadManager) dependenciesMet(download *Download) bool {
+    ready, _ := dm.dependencyState(download.ID)
+    return ready
+}
+
+func isFailure(status DownloadStatus) bool {
+    switch status {
+    case StatusFailed, StatusCancelled, StatusVerificationFailed, StatusBlocked:
+        return true
+    }
+    return false
+}
+
+// checkBlocked blocks a waiting download whose dependency failed
+func (dm *DownloadManager) checkBlocked(download *Download) error {
+    if download.Status != StatusPending && download.Status != StatusPaused {
+        return nil
+    }
+
+    if _, failed := dm.dependencyState(download.ID); failed != nil {
+        return dm.block(download, failed)
+    }
+    return nil
+}
+
+// block takes a download out of its queue because a download it waits for
+// failed, and blocks the downloads waiting for it in turn
+func (dm *DownloadManager) block(download *Download, failed *Download) error {
+    dm.removeFromQueues(download.ID)
+
+    download.Status = StatusBlocked
+    download.Speed = 0
+    download.Error = fmt.Sprintf("waiting for %s, which %s", failed.Filename, describeFailure(failed.Status))
+    if err := dm.store.UpdateDownload(download); err != nil {
+        return err
+    }
+    dm.publishStatus(download)
+
+    return dm.blockDependents(download)
+}
+
+func describeFailure(status DownloadStatus) string {
+    switch status {
+    case StatusBlocked:
+        return "is blocked"
+    case StatusVerificationFailed:
+        return "failed verification"
+    default:
+        return strings.ToLower(status.String())
+    }
+}
+
+// blockDependents blocks the waiting downloads that wait for a download
+// which failed
+func (dm *DownloadManager) blockDependents(failed *Download) error {
+    dependents, err := dm.store.GetDependents(failed.ID)
+    if err != nil {
+        return err
+    }
+
+    for _, id := range dependents {
+        dependent, err := dm.store.GetDownload(id)
+        if err != nil {
+            return err
+        }
+        if dependent.Status != StatusPending && dependent.Status != StatusPaused {
+            continue
+        }
+        if err := dm.block(dependent, failed); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// unblock queues a blocked download again once none of the downloads it
+// waits for has failed, and unblocks the downloads waiting for it
+func (dm *DownloadManager) unblock(download *Download) error {
+    if _, failed := dm.dependencyState(download.ID); failed != nil {
+        return nil
+    }
+
+    download.Status = StatusPending
+    download.Error = ""
+    if err := dm.store.UpdateDownload(download); err != nil {
+        return err
+    }
+    dm.enqueue(download)
+    dm.publishStatus(download)
+
+    return dm.unblockDependents(download.ID)
+}
+
+// unblockDependents gives the blocked downloads waiting for a download
+// another chance, after it was retried or queued again
+func (dm *DownloadManager) unblockDependents(downloadID int64) error {
+    dependents, err := dm.store.GetDependents(downloadID)
+    if err != nil {
+        return err
+    }
+
+    for _, id := range dependents {
+        dependent, err := dm.store.GetDownload(id)
+        if err != nil {
+            return err
+        }
+        if dependent.Status != StatusBlocked {
+            continue
+        }
+        if err := dm.unblock(dependent); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
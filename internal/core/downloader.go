@@ -2,27 +2,44 @@ package core
 
 import (
     "context"
+    "errors"
     "fmt"
     "io"
     "net/http"
     "os"
-    "path/filepath"
     "strconv"
+    "strings"
     "sync"
     "sync/atomic"
     "time"
-    "database/sql"
     "idm-go/internal/storage"
 )
 
+// progressInterval is how often the progress of running downloads is
+// written to the database
+const progressInterval = 2 * time.Second
+
 type DownloadManager struct {
-    db              *sql.DB
-    config          *DownloadConfig
-    downloads       map[int64]*DownloadJob
-    queue           *Queue
-    activeDownloads int32
-    mutex           sync.RWMutex
-    callbacks       []func(*storage.Download)
+    store                 storage.Repository
+    config                *DownloadConfig // replaced, never changed, see Config
+    configMutex           sync.RWMutex
+    downloads             map[int64]*DownloadJob
+    queues                map[int64]*Queue
+    queuesMutex           sync.RWMutex
+    limiter               *BandwidthLimiter
+    clock                 Clock
+    scheduleChanged       chan struct{} // wakes runBandwidthSchedule
+    queueSchedulesChanged chan struct{} // wakes runQueueScheduler
+    activeDownloads       int32
+    stopLoops             context.CancelFunc // ends the loops run by Start
+    loops                 sync.WaitGroup
+    jobs                  sync.WaitGroup // running executeDownload calls
+    closing               bool           // set by Shutdown, guarded by mutex
+    mutex                 sync.RWMutex
+    pathMutex             sync.Mutex // serializes target name decisions
+    events                *eventBus
+    progress              *storage.ProgressWriter
+    checksumListings      *checksumCache
 }
 
 type DownloadJob struct {
@@ -30,81 +47,208 @@ type DownloadJob struct {
     ctx        context.Context
     cancel     context.CancelFunc
     client     *http.Client
+    flow       *Flow // share of the global bandwidth
     chunks     []*ChunkDownloader
     mutex      sync.RWMutex
-    lastUpdate time.Time
+    downloaded int64      // bytes on disk, counted by the workers with atomics
+    finished   bool       // the final state is being written, no more progress
+    speed      speedMeter // used by updateStats only
+    stopStatus DownloadStatus // status requested by Pause/Cancel, zero while running
+    requeue    bool           // paused by StopQueue, goes back to the queue
+    aborted    bool           // a chunk failed, no new work is handed out
+    sidecarMutex sync.Mutex   // held by updateStats while it writes the sidecar
 }
 
 type ChunkDownloader struct {
     start      int64
     end        int64
     downloaded int64
+    attempts   int
+    lastError  string
+    active     bool // a worker is fetching this chunk, guarded by the job mutex
     file       *os.File
+    speed      speedMeter
+    rate       int64 // smoothed bytes per second while active
     mutex      sync.RWMutex
 }
 
-func NewDownloadManager(db *sql.DB) *DownloadManager {
+// NewDownloadManager restores the configuration, downloads and queues kept
+// in store
+func NewDownloadManager(store storage.Repository) (*DownloadManager, error) {
+    config := DefaultConfig()
     dm := &DownloadManager{
-        db:        db,
-        config:    DefaultConfig(),
-        downloads: make(map[int64]*DownloadJob),
-        queue:     NewQueue(),
+        store:                 store,
+        config:                config,
+        downloads:             make(map[int64]*DownloadJob),
+        queues:                make(map[int64]*Queue),
+        limiter:               NewBandwidthLimiter(config.MaxSpeed),
+        clock:                 SystemClock,
+        scheduleChanged:       make(chan struct{}, 1),
+        queueSchedulesChanged: make(chan struct{}, 1),
+        events:                newEventBus(),
+        progress:              storage.NewProgressWriter(store, progressInterval),
+        checksumListings:      newChecksumCache(),
     }
-    
-    go dm.processQueue()
-    go dm.updateStats()
-    
-    return dm
+    // First, AutoResume decides what happens to interrupted downloads
+    if err := dm.loadSettings(); err != nil {
+        return nil, fmt.Errorf("loading settings: %w", err)
+    }
+    if err := dm.loadQueues(); err != nil {
+        return nil, fmt.Errorf("loading queues: %w", err)
+    }
+    if err := dm.restoreQueues(); err != nil {
+        return nil, fmt.Errorf("restoring queues: %w", err)
+    }
+
+    return dm, nil
 }
 
 func (dm *DownloadManager) AddDownload(url, path string) (*storage.Download, error) {
+    return dm.AddDownloadWithOptions(url, path, DownloadOptions{})
+}
+
+func (dm *DownloadManager) AddDownloadWithOptions(url, path string, options DownloadOptions) (*storage.Download, error) {
+    var algorithm, checksum string
+    if options.Checksum != "" {
+        var err error
+        algorithm, checksum, err = NormalizeChecksum(options.ChecksumAlgorithm, options.Checksum)
+        if err != nil {
+            return nil, err
+        }
+    }
+    if options.QueueID != 0 {
+        if _, err := dm.queueByID(options.QueueID); err != nil {
+            return nil, err
+        }
+    }
+    for _, id := range options.DependsOn {
+        if _, err := dm.store.GetDownload(id); err != nil {
+            return nil, fmt.Errorf("download %d to wait for not found", id)
+        }
+    }
+    if options.PackageID != 0 {
+        if _, err := dm.store.GetPackage(options.PackageID); err != nil {
+            return nil, fmt.Errorf("package %d not found", options.PackageID)
+        }
+    }
+
     // Get file info
-    resp, err := http.Head(url)
+    resp, err := dm.probe(url)
     if err != nil {
         return nil, err
     }
-    defer resp.Body.Close()
 
-    filename := filepath.Base(url)
-    if disposition := resp.Header.Get("Content-Disposition"); disposition != "" {
-        // Parse filename from Content-Disposition header
-        // Simplified implementation
-    }
-
-    size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+    filename := resolveFilename(resp, url)
 
     download := &storage.Download{
-        URL:       url,
-        Filename:  filename,
-        Path:      path,
-        Size:      size,
-        Status:    storage.StatusPending,
-        CreatedAt: time.Now(),
-        Chunks:    4, // Default chunks
+        URL:        url,
+        Filename:   filename,
+        Path:       path,
+        Status:     storage.StatusPending,
+        CreatedAt:  time.Now(),
+        Chunks:     4, // Default chunks
+        Conflict:   options.ConflictPolicy,
+        SpeedLimit: options.SpeedLimit,
+        Weight:     storage.DefaultWeight,
+        QueueID:    storage.MainQueueID,
+        Priority:   options.Priority,
+        PackageID:  options.PackageID,
+    }
+    if options.Chunks > 0 {
+        download.Chunks = options.Chunks
+    }
+    if options.Weight > 0 {
+        download.Weight = options.Weight
+    }
+    if options.QueueID != 0 {
+        download.QueueID = options.QueueID
     }
 
-    // Save to database
-    id, err := storage.SaveDownload(dm.db, download)
+    // A checksum given by the user wins over one announced by the server,
+    // which wins over a published checksum file, looked for once the
+    // transfer is done
+    if checksum != "" {
+        download.ChecksumAlgorithm = algorithm
+        download.Checksum = checksum
+        download.ChecksumSource = storage.ChecksumFromUser
+    }
+    applyRemoteInfo(download, resp.Header)
+
+    // The name is only settled once the download is stored, so the check
+    // and the insert must not interleave with another AddDownload
+    dm.pathMutex.Lock()
+    err = dm.applyConflictPolicy(download)
+    if err == nil {
+        download.ID, err = dm.store.SaveDownload(download)
+    }
+    dm.pathMutex.Unlock()
     if err != nil {
         return nil, err
     }
-    download.ID = id
 
-    // Add to queue
-    dm.queue.Add(download)
-    dm.notifyCallbacks(download)
+    // A new download has no dependents, so these links cannot form a cycle
+    for _, id := range options.DependsOn {
+        if err := dm.store.AddDependency(download.ID, id); err != nil {
+            return nil, err
+        }
+    }
+    if err := dm.checkBlocked(download); err != nil {
+        return nil, err
+    }
+
+    // Add to queue, unless the file was already there
+    if download.Status == StatusPending {
+        dm.enqueue(download)
+    }
+    dm.publish(EventAdded, download)
 
     return download, nil
 }
 
+// probe fetches the headers of a URL, following redirects. Servers that
+// reject HEAD are asked with a GET whose body is discarded.
+func (dm *DownloadManager) probe(url string) (*http.Response, error) {
+    client := dm.newHTTPClient()
+
+    var lastErr error
+    for _, method := range []string{"HEAD", "GET"} {
+        req, err := http.NewRequest(method, url, nil)
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("User-Agent", dm.Config().UserAgent)
+
+        resp, err := client.Do(req)
+        if err != nil {
+            lastErr = err
+            continue
+        }
+        resp.Body.Close()
+
+        if resp.StatusCode >= 400 {
+            lastErr = newHTTPStatusError(resp)
+            continue
+        }
+        return resp, nil
+    }
+
+    return nil, lastErr
+}
+
 func (dm *DownloadManager) StartDownload(id int64) error {
-    download, err := storage.GetDownload(dm.db, id)
+    download, err := dm.store.GetDownload(id)
     if err != nil {
         return err
     }
 
-    if download.Status == storage.StatusDownloading {
-        return fmt.Errorf("download already in progress")
+    // Started by hand or by the queue, a download waits for its
+    // dependencies, and a blocked one stays blocked
+    ready, failed := dm.dependencyState(id)
+    if failed != nil {
+        return fmt.Errorf("waiting for %s, which %s", failed.Filename, describeFailure(failed.Status))
+    }
+    if !ready {
+        return fmt.Errorf("waiting for the downloads it depends on to complete")
     }
 
     ctx, cancel := context.WithCancel(context.Background())
@@ -113,132 +257,520 @@ func (dm *DownloadManager) StartDownload(id int64) error {
         download: download,
         ctx:      ctx,
         cancel:   cancel,
-        client: &http.Client{
-            Timeout: dm.config.Timeout,
-        },
-        lastUpdate: time.Now(),
+        client:     dm.newHTTPClient(),
+        flow:       dm.limiter.Register(download.QueueID, download.Weight, download.SpeedLimit),
+        downloaded: download.Downloaded,
     }
 
+    // The stored status may still say "Downloading" after a crash, so only
+    // the in-memory job table tells whether it is really running. It is
+    // checked and claimed in one step, or a Start from the UI racing the
+    // queue could run two jobs on the same .part file.
     dm.mutex.Lock()
+    _, running := dm.downloads[id]
+    if running || dm.closing {
+        dm.mutex.Unlock()
+        cancel()
+        job.flow.Close()
+        if running {
+            return fmt.Errorf("download already in progress")
+        }
+        return errShuttingDown
+    }
     dm.downloads[id] = job
+    dm.jobs.Add(1)
     dm.mutex.Unlock()
 
+    dm.removeFromQueues(id)
+
+    if download.Status == StatusVerificationFailed {
+        if err := dm.discardFailedVerification(job); err != nil {
+            dm.releaseJob(job)
+            return err
+        }
+    }
+
     go dm.executeDownload(job)
     
     return nil
 }
 
-// ... resto des méthodes avec storage.Download au lieu de core.Download ...
-
-func (dm *DownloadManager) GetDownloads() ([]*storage.Download, error) {
-    return storage.GetAllDownloads(dm.db)
-}
-
-func (dm *DownloadManager) AddCallback(callback func(*storage.Download)) {
-    dm.callbacks = append(dm.callbacks, callback)
-}
-
-func (dm *DownloadManager) notifyCallbacks(download *storage.Download) {
-    for _, callback := range dm.callbacks {
-        go callback(download)
-    }
-}
-
-// Reste des méthodes similaires en remplaçant core.Download par storage.Download...
-
 func (dm *DownloadManager) executeDownload(job *DownloadJob) {
+    defer dm.jobs.Done()
     atomic.AddInt32(&dm.activeDownloads, 1)
     defer atomic.AddInt32(&dm.activeDownloads, -1)
+    defer job.flow.Close()
 
     download := job.download
+    job.mutex.Lock()
     download.Status = StatusDownloading
-    now := time.Now()
-    download.StartedAt = &now
-    
+    download.Error = ""
+    download.Retries = 0
+    download.RetryError = ""
+    if download.StartedAt == nil {
+        now := time.Now()
+        download.StartedAt = &now
+    }
+    job.mutex.Unlock()
+
+    job.mutex.RLock()
     dm.updateDownload(download)
+    dm.publish(EventStarted, download)
+    job.mutex.RUnlock()
+
+    err := dm.transfer(job)
+    if errors.Is(err, errRemoteChanged) && job.ctx.Err() == nil {
+        // Start over once with the new file
+        if resp, probeErr := dm.probe(download.URL); probeErr != nil {
+            err = probeErr
+        } else {
+            dm.discardPartialData(job, resp.Header)
+            err = dm.transfer(job)
+        }
+    }
 
-    // Check if server supports range requests
-    supportsRange := dm.supportsRangeRequests(download.URL)
-    
-    var err error
-    if supportsRange && download.Size > dm.config.ChunkSize {
-        err = dm.downloadWithChunks(job)
-    } else {
-        err = dm.downloadSingleFile(job)
+    if err == nil {
+        err = dm.lookUpChecksum(job)
     }
 
-    if err != nil {
-        download.Status = StatusFailed
-        download.Error = err.Error()
-    } else {
-        download.Status = StatusCompleted
+    stopStatus := job.getStopStatus()
+
+    // Progress recorded from here on would overwrite the final state, and
+    // a sidecar written from here on could outlive the files
+    job.sidecarMutex.Lock()
+    job.mutex.Lock()
+    job.finished = true
+    job.mutex.Unlock()
+    job.sidecarMutex.Unlock()
+    dm.progress.Forget(download.ID)
+
+    if err == nil {
+        err = dm.verifyDownload(download)
+    }
+    if err == nil {
+        err = dm.finishPartialFile(download)
+    }
+
+    var checksumErr *ChecksumError
+
+    status := StatusFailed
+    switch {
+    case err == nil:
+        status = StatusCompleted
+        dm.store.DeleteChunks(download.ID)
+    case errors.As(err, &checksumErr):
+        // Keep the .part file for inspection, but a restart begins from scratch
+        status = StatusVerificationFailed
+        dm.store.DeleteChunks(download.ID)
+        removeSidecar(download)
+    case stopStatus == StatusCancelled:
+        status = StatusCancelled
+        dm.store.DeleteChunks(download.ID)
+        removePartialFiles(download)
+    case stopStatus == StatusPaused && job.requeued():
+        // Stopped with its queue, it resumes when the queue starts again
+        status = StatusPending
+        dm.saveChunks(job)
+    case stopStatus == StatusPaused:
+        status = StatusPaused
+        dm.saveChunks(job)
+    default:
+        dm.saveChunks(job)
+    }
+
+    segments := job.segments()
+
+    job.mutex.Lock()
+    download.Status = status
+    switch status {
+    case StatusCompleted:
         now := time.Now()
         download.CompletedAt = &now
         download.Progress = 100.0
+    case StatusVerificationFailed, StatusFailed:
+        download.Error = err.Error()
+    }
+    download.Downloaded = atomic.LoadInt64(&job.downloaded)
+    if download.Size > 0 && download.Status != StatusCompleted {
+        download.Progress = float64(download.Downloaded) / float64(download.Size) * 100
     }
+    download.Speed = 0
+    download.ETA = 0
+    download.Segments = segments
+    job.mutex.Unlock()
 
+    job.mutex.RLock()
     dm.updateDownload(download)
-    dm.notifyCallbacks(download)
+    dm.publishStatus(download)
+    job.mutex.RUnlock()
 
+    // Before the job is gone, so that the next one starts tracked afresh
+    dm.events.forget(download.ID)
     dm.mutex.Lock()
     delete(dm.downloads, download.ID)
     dm.mutex.Unlock()
+
+    // Only once the job is gone, or the queue could not start it again.
+    // While shutting down it stays Pending in the database and the next
+    // start queues it.
+    if download.Status == StatusPending && !dm.isClosing() {
+        dm.enqueue(download)
+    }
+
+    // The downloads waiting for this one follow its outcome
+    switch {
+    case download.Status == StatusCompleted:
+        dm.unblockDependents(download.ID)
+        dm.checkPackageComplete(download)
+    case isFailure(download.Status):
+        dm.blockDependents(download)
+    }
 }
 
-func (dm *DownloadManager) supportsRangeRequests(url string) bool {
-    req, _ := http.NewRequest("HEAD", url, nil)
-    resp, err := dm.downloads[0].client.Do(req)
-    if err != nil {
-        return false
+// discardFailedVerification makes a download that failed verification
+// start over: the bytes on disk do not match the checksum, resuming from
+// them would only fail again
+func (dm *DownloadManager) discardFailedVerification(job *DownloadJob) error {
+    download := job.download
+    removePartialFiles(download)
+    if err := dm.store.DeleteChunks(download.ID); err != nil {
+        return err
     }
-    defer resp.Body.Close()
-    
-    return resp.Header.Get("Accept-Ranges") == "bytes"
+
+    job.mutex.Lock()
+    download.Downloaded = 0
+    download.Progress = 0
+    download.Segments = nil
+    atomic.StoreInt64(&job.downloaded, 0)
+    job.mutex.Unlock()
+
+    job.mutex.RLock()
+    defer job.mutex.RUnlock()
+    return dm.store.UpdateDownload(download)
 }
 
-func (dm *DownloadManager) downloadWithChunks(job *DownloadJob) error {
+// releaseJob gives up a job claimed by StartDownload before it ran
+func (dm *DownloadManager) releaseJob(job *DownloadJob) {
+    dm.mutex.Lock()
+    delete(dm.downloads, job.download.ID)
+    dm.mutex.Unlock()
+
+    job.cancel()
+    job.flow.Close()
+    dm.jobs.Done()
+}
+
+// transfer fetches the remaining bytes of a download
+func (dm *DownloadManager) transfer(job *DownloadJob) error {
     download := job.download
-    chunkSize := download.Size / int64(download.Chunks)
-    
-    // Create file
-    fullPath := filepath.Join(download.Path, download.Filename)
-    file, err := os.Create(fullPath)
+
+    // Check if server supports range requests
+    supportsRange, err := dm.checkRemote(job)
     if err != nil {
+        // Without an answer nothing on disk is touched, a later start
+        // resumes from it
         return err
     }
-    defer file.Close()
 
-    // Pre-allocate file
-    if err := file.Truncate(download.Size); err != nil {
+    if supportsRange && download.Size > dm.Config().ChunkSize {
+        return dm.downloadWithChunks(job)
+    }
+    return dm.downloadSingleFile(job, supportsRange)
+}
+
+// checkRemote asks the server whether it supports range requests. When the
+// file changed since the download was added, the partial data is discarded.
+// Transient failures are retried like transfers; an error means the server
+// could not be asked, which is not the same as having no range support.
+func (dm *DownloadManager) checkRemote(job *DownloadJob) (bool, error) {
+    failures := 0
+
+    for {
+        header, supportsRange, err := dm.probeRanges(job)
+        if err == nil {
+            if remoteChanged(job.download, header) {
+                dm.discardPartialData(job, header)
+            }
+            return supportsRange, nil
+        }
+        if job.ctx.Err() != nil {
+            return false, job.ctx.Err()
+        }
+
+        failures++
+        if !isRetryable(err) || failures > dm.Config().RetryAttempts {
+            return false, err
+        }
+
+        job.recordRetry(err)
+        if err := sleepContext(job.ctx, retryDelay(failures, err)); err != nil {
+            return false, err
+        }
+    }
+}
+
+// probeRanges sends a HEAD, and when that fails a GET for the first byte,
+// which also works with servers that reject HEAD. It returns the headers
+// describing the whole file.
+func (dm *DownloadManager) probeRanges(job *DownloadJob) (http.Header, bool, error) {
+    resp, err := dm.requestRemote(job, "HEAD", "")
+    if err == nil && resp.StatusCode < 300 {
+        return resp.Header, resp.Header.Get("Accept-Ranges") == "bytes", nil
+    }
+
+    resp, err = dm.requestRemote(job, "GET", "bytes=0-0")
+    if err != nil {
+        return nil, false, err
+    }
+
+    switch {
+    case resp.StatusCode == http.StatusPartialContent:
+        // Content-MD5 and Digest may be of the one byte sent; only
+        // Repr-Digest is sure to be of the whole file
+        header := resp.Header.Clone()
+        header.Del("Content-MD5")
+        header.Del("Digest")
+        header.Del("Content-Length")
+        if size := contentRangeSize(resp.Header.Get("Content-Range")); size > 0 {
+            header.Set("Content-Length", strconv.FormatInt(size, 10))
+        }
+        return header, true, nil
+    case resp.StatusCode >= 200 && resp.StatusCode < 300:
+        // The range was ignored
+        return resp.Header, false, nil
+    default:
+        return nil, false, newHTTPStatusError(resp)
+    }
+}
+
+// requestRemote sends a request for the headers only; the body is not read
+func (dm *DownloadManager) requestRemote(job *DownloadJob, method, byteRange string) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(job.ctx, method, job.download.URL, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("User-Agent", dm.Config().UserAgent)
+    if byteRange != "" {
+        req.Header.Set("Range", byteRange)
+    }
+
+    resp, err := job.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    resp.Body.Close()
+
+    return resp, nil
+}
+
+// contentRangeSize returns the complete length from a Content-Range header
+// like "bytes 0-0/5000000", or -1 when the server did not say
+func contentRangeSize(value string) int64 {
+    slash := strings.LastIndex(value, "/")
+    if slash < 0 {
+        return -1
+    }
+    size, err := strconv.ParseInt(value[slash+1:], 10, 64)
+    if err != nil {
+        return -1
+    }
+    return size
+}
+
+// verifyDownload checks a finished .part file against the expected digest
+func (dm *DownloadManager) verifyDownload(download *storage.Download) error {
+    if download.Checksum == "" {
+        return nil
+    }
+
+    fullPath, err := targetPath(download)
+    if err != nil {
         return err
     }
 
-    var wg sync.WaitGroup
-    errChan := make(chan error, download.Chunks)
+    return verifyFile(fullPath+partSuffix, download.Size, download.ChecksumAlgorithm, download.Checksum)
+}
+
+// newHTTPClient builds a client without an overall timeout, which would also
+// abort long transfers. Stalled transfers are caught by stallWatchdog.
+func (dm *DownloadManager) newHTTPClient() *http.Client {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.ResponseHeaderTimeout = dm.Config().Timeout
+
+    return &http.Client{Transport: transport}
+}
+
+// loadChunks restores the persisted chunk layout of a download from the
+// database, or from the sidecar next to the .part file. It returns nil when
+// there is nothing usable to resume from and a fresh layout has to be planned.
+func (dm *DownloadManager) loadChunks(download *storage.Download, file *os.File) []*ChunkDownloader {
+    stored, err := dm.store.GetChunks(download.ID)
+    if err == nil && len(stored) > 0 {
+        if chunks := restoreChunks(download, stored, file); chunks != nil {
+            return chunks
+        }
+    }
+
+    return restoreChunks(download, readSidecar(download), file)
+}
+
+func restoreChunks(download *storage.Download, stored []*storage.Chunk, file *os.File) []*ChunkDownloader {
+    if len(stored) == 0 {
+        return nil
+    }
+
+    // The ranges must still tile the whole file, otherwise the remote size
+    // changed and the partial data cannot be trusted.
+    var next int64
+    chunks := make([]*ChunkDownloader, 0, len(stored))
+    for _, c := range stored {
+        if c.Start != next || c.End < c.Start || c.Downloaded < 0 || c.Downloaded > c.End-c.Start+1 {
+            return nil
+        }
+        next = c.End + 1
+        chunks = append(chunks, &ChunkDownloader{
+            start:      c.Start,
+            end:        c.End,
+            downloaded: c.Downloaded,
+            attempts:   c.Attempts,
+            lastError:  c.LastError,
+            file:       file,
+        })
+    }
+    if next != download.Size {
+        return nil
+    }
+
+    return chunks
+}
+
+// planFreshChunks lays out a download that has no stored progress. A file
+// that is already there is only kept under ConflictResume, where its bytes
+// count as the start of the download.
+func planFreshChunks(download *storage.Download, file *os.File, existed bool) ([]*ChunkDownloader, error) {
+    if existed && download.Conflict == ConflictResume {
+        info, err := file.Stat()
+        if err != nil {
+            return nil, err
+        }
+        if info.Size() > 0 && info.Size() <= download.Size {
+            // Work stealing spreads the rest over the other connections
+            return []*ChunkDownloader{{
+                start:      0,
+                end:        download.Size - 1,
+                downloaded: info.Size(),
+                file:       file,
+            }}, nil
+        }
+    }
+
+    if err := file.Truncate(0); err != nil {
+        return nil, err
+    }
+    return planChunks(download.Size, download.Chunks, file), nil
+}
+
+func planChunks(size int64, count int, file *os.File) []*ChunkDownloader {
+    if count < 1 {
+        count = 1
+    }
+    chunkSize := size / int64(count)
 
-    // Create chunks
-    for i := 0; i < download.Chunks; i++ {
+    chunks := make([]*ChunkDownloader, 0, count)
+    for i := 0; i < count; i++ {
         start := int64(i) * chunkSize
         end := start + chunkSize - 1
-        if i == download.Chunks-1 {
-            end = download.Size - 1
+        if i == count-1 {
+            end = size - 1
         }
 
-        chunk := &ChunkDownloader{
+        chunks = append(chunks, &ChunkDownloader{
             start: start,
             end:   end,
             file:  file,
+        })
+    }
+
+    return chunks
+}
+
+// saveChunks persists the current offset of every chunk so the download can
+// be resumed later, even after the process was killed.
+func (dm *DownloadManager) saveChunks(job *DownloadJob) {
+    chunks := job.segments()
+    if len(chunks) == 0 {
+        return
+    }
+
+    dm.store.SaveChunks(job.download.ID, chunks)
+    writeSidecar(job.download, chunks)
+}
+
+func (dm *DownloadManager) downloadWithChunks(job *DownloadJob) error {
+    download := job.download
+    
+    job.mutex.RLock()
+    file, existed, err := openPartialFile(download)
+    job.mutex.RUnlock()
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    var chunks []*ChunkDownloader
+    if existed {
+        chunks = dm.loadChunks(download, file)
+    }
+    if chunks == nil {
+        chunks, err = planFreshChunks(download, file, existed)
+        if err != nil {
+            return err
         }
+    }
+
+    // Pre-allocate file
+    if err := file.Truncate(download.Size); err != nil {
+        return err
+    }
 
-        job.chunks = append(job.chunks, chunk)
+    var downloaded int64
+    for _, chunk := range chunks {
+        downloaded += chunk.downloaded
+    }
+    atomic.StoreInt64(&job.downloaded, downloaded)
+
+    job.mutex.Lock()
+    job.chunks = chunks
+    job.aborted = false
+    job.mutex.Unlock()
+    dm.saveChunks(job)
+
+    workers := download.Chunks
+    if workers < 1 {
+        workers = 1
+    }
 
+    var wg sync.WaitGroup
+    errChan := make(chan error, workers)
+
+    for i := 0; i < workers; i++ {
         wg.Add(1)
-        go func(chunk *ChunkDownloader) {
+        go func() {
             defer wg.Done()
-            if err := dm.downloadChunk(job, chunk); err != nil {
-                errChan <- err
+            for {
+                chunk := job.nextChunk(dm.Config().ChunkSize)
+                if chunk == nil {
+                    return
+                }
+
+                err := dm.downloadChunk(job, chunk)
+                job.releaseChunk(chunk, err)
+                if err != nil {
+                    errChan <- err
+                    return
+                }
             }
-        }(chunk)
+        }()
     }
 
     wg.Wait()
@@ -251,28 +783,83 @@ func (dm *DownloadManager) downloadWithChunks(job *DownloadJob) error {
         }
     }
 
-    return nil
+    for _, chunk := range job.chunks {
+        if chunk.remaining() > 0 {
+            return fmt.Errorf("download stopped with %d bytes missing", chunk.remaining())
+        }
+    }
+
+    return file.Sync()
 }
 
+// downloadChunk fetches a chunk, retrying transient failures from the
+// chunk's current offset. Only consecutive failures without progress count
+// against RetryAttempts.
 func (dm *DownloadManager) downloadChunk(job *DownloadJob, chunk *ChunkDownloader) error {
-    req, err := http.NewRequestWithContext(job.ctx, "GET", job.download.URL, nil)
+    failures := 0
+
+    for {
+        before := chunk.remaining()
+        err := dm.fetchChunk(job, chunk)
+        if err == nil {
+            return nil
+        }
+        if job.ctx.Err() != nil {
+            return job.ctx.Err()
+        }
+
+        if chunk.remaining() < before {
+            failures = 0
+        }
+        failures++
+        chunk.recordFailure(err)
+
+        if !isRetryable(err) || failures > dm.Config().RetryAttempts {
+            return err
+        }
+
+        job.recordRetry(err)
+        if err := sleepContext(job.ctx, retryDelay(failures, err)); err != nil {
+            return err
+        }
+    }
+}
+
+func (dm *DownloadManager) fetchChunk(job *DownloadJob, chunk *ChunkDownloader) error {
+    offset, end := chunk.position()
+    if offset > end {
+        return nil
+    }
+
+    ctx, cancel := context.WithCancel(job.ctx)
+    defer cancel()
+    watchdog := newStallWatchdog(dm.Config().Timeout, cancel)
+    defer watchdog.Stop()
+
+    req, err := http.NewRequestWithContext(ctx, "GET", job.download.URL, nil)
     if err != nil {
         return err
     }
 
-    req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", chunk.start, chunk.end))
-    req.Header.Set("User-Agent", dm.config.UserAgent)
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end))
+    req.Header.Set("User-Agent", dm.Config().UserAgent)
+    ifRange := ifRangeValue(job.download)
+    if ifRange != "" {
+        req.Header.Set("If-Range", ifRange)
+    }
 
     resp, err := job.client.Do(req)
     if err != nil {
-        return err
+        return watchdog.wrap(err)
     }
     defer resp.Body.Close()
 
-    // Create a rate-limited reader if speed limit is set
-    var reader io.Reader = resp.Body
-    if dm.config.MaxSpeed > 0 {
-        reader = newRateLimitedReader(resp.Body, dm.config.MaxSpeed/int64(job.download.Chunks))
+    if resp.StatusCode == http.StatusOK && ifRange != "" {
+        // The validator no longer matches, so the server sent the whole new file
+        return errRemoteChanged
+    }
+    if resp.StatusCode != http.StatusPartialContent {
+        return newHTTPStatusError(resp)
     }
 
     buffer := make([]byte, 32*1024) // 32KB buffer
@@ -284,12 +871,19 @@ func (dm *DownloadManager) downloadChunk(job *DownloadJob, chunk *ChunkDownloade
         default:
         }
 
-        n, err := reader.Read(buffer)
+        n, err := resp.Body.Read(buffer[:job.flow.ReadSize(len(buffer))])
         if n > 0 {
+            watchdog.Reset()
+
             // Write to file at specific offset
             chunk.mutex.Lock()
+            if remaining := chunk.end - chunk.start + 1 - chunk.downloaded; int64(n) > remaining {
+                n = int(remaining)
+            }
             _, writeErr := chunk.file.WriteAt(buffer[:n], chunk.start+chunk.downloaded)
-            chunk.downloaded += int64(n)
+            if writeErr == nil {
+                chunk.downloaded += int64(n)
+            }
             chunk.mutex.Unlock()
 
             if writeErr != nil {
@@ -297,47 +891,131 @@ func (dm *DownloadManager) downloadChunk(job *DownloadJob, chunk *ChunkDownloade
             }
 
             // Update total downloaded
-            atomic.AddInt64(&job.download.Downloaded, int64(n))
+            atomic.AddInt64(&job.downloaded, int64(n))
+
+            // The watchdog must not count time spent waiting for bandwidth
+            watchdog.Stop()
+            if err := job.flow.WaitN(job.ctx, n); err != nil {
+                return err
+            }
+            watchdog.Reset()
         }
 
+        if chunk.remaining() == 0 {
+            return nil
+        }
         if err == io.EOF {
-            break
+            return errShortBody
         }
         if err != nil {
-            return err
+            return watchdog.wrap(err)
         }
     }
+}
 
-    return nil
+// downloadSingleFile fetches the file over one connection. After a transient
+// failure it continues from the bytes already written when the server
+// supports ranges, and starts over otherwise.
+func (dm *DownloadManager) downloadSingleFile(job *DownloadJob, supportsRange bool) error {
+    download := job.download
+
+    job.mutex.RLock()
+    file, existed, err := openPartialFile(download)
+    job.mutex.RUnlock()
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    // Without chunk ranges the bytes already on disk are the resume offset,
+    // as long as they come from an earlier run or the policy says to keep them.
+    var offset int64
+    if existed && supportsRange && (atomic.LoadInt64(&job.downloaded) > 0 || download.Conflict == ConflictResume) {
+        if info, err := file.Stat(); err == nil {
+            offset = info.Size()
+        }
+    }
+
+    failures := 0
+
+    for {
+        before := offset
+        err := dm.fetchSingleFile(job, file, &offset, supportsRange)
+        if err == nil {
+            return file.Sync()
+        }
+        if job.ctx.Err() != nil {
+            return job.ctx.Err()
+        }
+
+        if offset > before {
+            failures = 0
+        }
+        failures++
+
+        if !isRetryable(err) || failures > dm.Config().RetryAttempts {
+            return err
+        }
+
+        job.recordRetry(err)
+        if err := sleepContext(job.ctx, retryDelay(failures, err)); err != nil {
+            return err
+        }
+    }
 }
 
-func (dm *DownloadManager) downloadSingleFile(job *DownloadJob) error {
+func (dm *DownloadManager) fetchSingleFile(job *DownloadJob, file *os.File, offset *int64, supportsRange bool) error {
     download := job.download
+    if !supportsRange {
+        *offset = 0
+    }
+
+    ctx, cancel := context.WithCancel(job.ctx)
+    defer cancel()
+    watchdog := newStallWatchdog(dm.Config().Timeout, cancel)
+    defer watchdog.Stop()
     
-    req, err := http.NewRequestWithContext(job.ctx, "GET", download.URL, nil)
+    req, err := http.NewRequestWithContext(ctx, "GET", download.URL, nil)
     if err != nil {
         return err
     }
     
-    req.Header.Set("User-Agent", dm.config.UserAgent)
+    req.Header.Set("User-Agent", dm.Config().UserAgent)
+    if *offset > 0 {
+        req.Header.Set("Range", fmt.Sprintf("bytes=%d-", *offset))
+        if ifRange := ifRangeValue(download); ifRange != "" {
+            req.Header.Set("If-Range", ifRange)
+        }
+    }
     
     resp, err := job.client.Do(req)
     if err != nil {
-        return err
+        return watchdog.wrap(err)
     }
     defer resp.Body.Close()
 
-    fullPath := filepath.Join(download.Path, download.Filename)
-    file, err := os.Create(fullPath)
-    if err != nil {
-        return err
+    switch {
+    case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && *offset > 0 && *offset == download.Size:
+        // Everything was already on disk
+        atomic.StoreInt64(&job.downloaded, *offset)
+        return nil
+    case resp.StatusCode == http.StatusPartialContent && *offset > 0:
+    case resp.StatusCode >= 200 && resp.StatusCode < 300:
+        // The server sent the whole body, start over
+        if *offset > 0 && remoteChanged(download, resp.Header) {
+            job.mutex.Lock()
+            replaceRemoteInfo(download, resp.Header)
+            job.mutex.Unlock()
+        }
+        *offset = 0
+    default:
+        return newHTTPStatusError(resp)
     }
-    defer file.Close()
 
-    var reader io.Reader = resp.Body
-    if dm.config.MaxSpeed > 0 {
-        reader = newRateLimitedReader(resp.Body, dm.config.MaxSpeed)
+    if err := file.Truncate(*offset); err != nil {
+        return err
     }
+    atomic.StoreInt64(&job.downloaded, *offset)
 
     buffer := make([]byte, 32*1024)
     
@@ -348,23 +1026,33 @@ func (dm *DownloadManager) downloadSingleFile(job *DownloadJob) error {
         default:
         }
 
-        n, err := reader.Read(buffer)
+        n, err := resp.Body.Read(buffer[:job.flow.ReadSize(len(buffer))])
         if n > 0 {
-            if _, writeErr := file.Write(buffer[:n]); writeErr != nil {
+            watchdog.Reset()
+            if _, writeErr := file.WriteAt(buffer[:n], *offset); writeErr != nil {
                 return writeErr
             }
-            atomic.AddInt64(&download.Downloaded, int64(n))
+            *offset += int64(n)
+            atomic.AddInt64(&job.downloaded, int64(n))
+
+            // The watchdog must not count time spent waiting for bandwidth
+            watchdog.Stop()
+            if err := job.flow.WaitN(job.ctx, n); err != nil {
+                return err
+            }
+            watchdog.Reset()
         }
 
         if err == io.EOF {
-            break
+            if download.Size > 0 && *offset < download.Size {
+                return errShortBody
+            }
+            return nil
         }
         if err != nil {
-            return err
+            return watchdog.wrap(err)
         }
     }
-
-    return nil
 }
 
 func (dm *DownloadManager) PauseDownload(id int64) error {
@@ -376,10 +1064,9 @@ func (dm *DownloadManager) PauseDownload(id int64) error {
         return fmt.Errorf("download not found")
     }
 
-    job.cancel()
-    job.download.Status = StatusPaused
-    dm.updateDownload(job.download)
-    dm.notifyCallbacks(job.download)
+    // executeDownload saves the chunk offsets and reports the paused state
+    // once the workers have stopped.
+    job.stop(StatusPaused)
 
     return nil
 }
@@ -390,120 +1077,388 @@ func (dm *DownloadManager) CancelDownload(id int64) error {
     dm.mutex.RUnlock()
 
     if exists {
-        job.cancel()
+        job.stop(StatusCancelled)
     }
+    dm.removeFromQueues(id)
 
     // Update status in database
-    download, err := storage.GetDownload(dm.db, id)
+    download, err := dm.store.GetDownload(id)
     if err != nil {
         return err
     }
 
     download.Status = StatusCancelled
+    download.Speed = 0
     dm.updateDownload(download)
-    dm.notifyCallbacks(download)
+    dm.store.DeleteChunks(id)
+    dm.publishStatus(download)
 
     // Remove partial file if exists
-    fullPath := filepath.Join(download.Path, download.Filename)
-    os.Remove(fullPath)
+    removePartialFiles(download)
+
+    dm.blockDependents(download)
+
+    return nil
+}
+
+// RemoveDownload deletes a download from the list. A running download is
+// cancelled first; the partial data of an unfinished one is removed, a
+// completed file stays.
+func (dm *DownloadManager) RemoveDownload(id int64) error {
+    download, err := dm.store.GetDownload(id)
+    if err != nil {
+        return err
+    }
+
+    if dm.isRunning(id) {
+        if err := dm.CancelDownload(id); err != nil {
+            return err
+        }
+    }
+    dm.removeFromQueues(id)
+
+    dependents, err := dm.store.GetDependents(id)
+    if err != nil {
+        return err
+    }
+    dm.progress.Forget(id)
+    if err := dm.store.DeleteDownload(id); err != nil {
+        return err
+    }
+    if download.Status != StatusCompleted {
+        removePartialFiles(download)
+    }
+    dm.publish(EventRemoved, download)
+
+    // Nothing waits for the removed download any more
+    for _, dependentID := range dependents {
+        dependent, err := dm.store.GetDownload(dependentID)
+        if err != nil {
+            return err
+        }
+        if dependent.Status == StatusBlocked {
+            dm.unblock(dependent)
+        }
+    }
+
+    return nil
+}
+
+// SetMaxSpeed changes the global bandwidth limit in bytes per second, 0 for
+// unlimited. It applies outside the windows of the bandwidth schedule, and
+// running downloads follow it right away.
+func (dm *DownloadManager) SetMaxSpeed(bytesPerSecond int64) error {
+    return dm.updateConfig(func(config *DownloadConfig) {
+        config.MaxSpeed = bytesPerSecond
+    })
+}
+
+// SetSpeedLimit changes the own bandwidth limit of a download in bytes per
+// second, 0 for none. A running download follows it right away.
+func (dm *DownloadManager) SetSpeedLimit(id int64, bytesPerSecond int64) error {
+    if bytesPerSecond < 0 {
+        return fmt.Errorf("speed limit must not be negative")
+    }
+
+    return dm.changeBandwidth(id, func(download *Download) {
+        download.SpeedLimit = bytesPerSecond
+    }, func(flow *Flow) {
+        flow.SetLimit(bytesPerSecond)
+    })
+}
+
+// SetWeight changes the share of the global bandwidth a download gets
+// relative to the other running downloads
+func (dm *DownloadManager) SetWeight(id int64, weight int) error {
+    if weight < 1 {
+        return fmt.Errorf("weight must be at least 1")
+    }
+
+    return dm.changeBandwidth(id, func(download *Download) {
+        download.Weight = weight
+    }, func(flow *Flow) {
+        flow.SetWeight(weight)
+    })
+}
+
+// changeBandwidth applies a bandwidth setting to the running job, if any,
+// and to the stored record
+func (dm *DownloadManager) changeBandwidth(id int64, apply func(*Download), applyFlow func(*Flow)) error {
+    dm.mutex.RLock()
+    job, running := dm.downloads[id]
+    dm.mutex.RUnlock()
+
+    if running {
+        job.mutex.Lock()
+        apply(job.download)
+        job.mutex.Unlock()
+        applyFlow(job.flow)
+
+        job.mutex.RLock()
+        defer job.mutex.RUnlock()
+        return dm.store.UpdateDownload(job.download)
+    }
+
+    download, err := dm.store.GetDownload(id)
+    if err != nil {
+        return err
+    }
+    apply(download)
+    if err := dm.store.UpdateDownload(download); err != nil {
+        return err
+    }
+    dm.publishStatus(download)
 
     return nil
 }
 
 func (dm *DownloadManager) GetDownloads() ([]*Download, error) {
-    return storage.GetAllDownloads(dm.db)
+    return dm.store.GetAllDownloads()
 }
 
+// updateDownload writes a download right away. Its progress still waiting
+// to be written is dropped, so that it cannot overwrite the new state.
 func (dm *DownloadManager) updateDownload(download *Download) {
-    storage.UpdateDownload(dm.db, download)
+    dm.progress.Forget(download.ID)
+    dm.store.UpdateDownload(download)
 }
 
-func (dm *DownloadManager) processQueue() {
+func (dm *DownloadManager) processQueue(ctx context.Context) {
     ticker := time.NewTicker(time.Second)
     defer ticker.Stop()
 
-    for range ticker.C {
-        if atomic.LoadInt32(&dm.activeDownloads) < int32(dm.config.MaxConcurrentDownloads) {
-            if download := dm.queue.Next(); download != nil {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        // Every queue keeps to its own limit, and all of them together to
+        // MaxConcurrentDownloads
+        for _, queue := range dm.queueList() {
+            if atomic.LoadInt32(&dm.activeDownloads) >= int32(dm.Config().MaxConcurrentDownloads) {
+                break
+            }
+
+            info := queue.Info()
+            if !info.Running || len(dm.queueJobs(info.ID)) >= info.MaxConcurrent {
+                continue
+            }
+            if download := queue.NextReady(dm.dependenciesMet); download != nil {
                 dm.StartDownload(download.ID)
             }
         }
     }
 }
 
-func (dm *DownloadManager) updateStats() {
+func (dm *DownloadManager) updateStats(ctx context.Context) {
     ticker := time.NewTicker(time.Second)
     defer ticker.Stop()
 
-    for range ticker.C {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        now := time.Now()
+
+        // Sidecars are written once the locks are released
+        type sidecarWrite struct {
+            job      *DownloadJob
+            download *Download
+            segments []*storage.Chunk
+        }
+        var sidecars []sidecarWrite
+
         dm.mutex.RLock()
         for _, job := range dm.downloads {
-            if job.download.Status == StatusDownloading {
-                // Calculate progress
-                if job.download.Size > 0 {
-                    job.download.Progress = float64(job.download.Downloaded) / float64(job.download.Size) * 100
-                }
+            job.sampleChunkSpeeds(now)
+            segments := job.segments()
 
-                // Calculate speed
-                now := time.Now()
-                if !job.lastUpdate.IsZero() {
-                    duration := now.Sub(job.lastUpdate)
-                    if duration > 0 {
-                        // This is a simplified speed calculation
-                        // In a real implementation, you'd track downloaded bytes over time
-                        job.download.Speed = int64(float64(job.download.Downloaded) / duration.Seconds())
-                    }
-                }
-                job.lastUpdate = now
+            job.mutex.Lock()
+            if job.finished || job.download.Status != StatusDownloading {
+                job.mutex.Unlock()
+                continue
+            }
+            download := job.download
+            download.Downloaded = atomic.LoadInt64(&job.downloaded)
 
-                dm.updateDownload(job.download)
-                dm.notifyCallbacks(job.download)
+            // Calculate progress
+            if download.Size > 0 {
+                download.Progress = float64(download.Downloaded) / float64(download.Size) * 100
             }
+
+            // Calculate speed
+            download.Speed = job.speed.sample(now, download.Downloaded)
+            download.ETA = estimateETA(download.Size, download.Downloaded, download.Speed)
+            download.Segments = segments
+
+            // Written in the next batch, unless the job finishes first
+            dm.progress.Record(&storage.Progress{
+                DownloadID: download.ID,
+                Downloaded: download.Downloaded,
+                Progress:   download.Progress,
+                Speed:      download.Speed,
+                Chunks:     segments,
+            })
+            dm.publish(EventProgress, download)
+            sidecars = append(sidecars, sidecarWrite{job, copyDownload(download), segments})
+            job.mutex.Unlock()
         }
         dm.mutex.RUnlock()
+
+        for _, write := range sidecars {
+            write.job.writeSidecar(write.download, write.segments)
+        }
+    }
+}
+
+// writeSidecar stores the chunk layout of a running job, unless it finished
+// meanwhile
+func (job *DownloadJob) writeSidecar(download *Download, segments []*storage.Chunk) {
+    job.sidecarMutex.Lock()
+    defer job.sidecarMutex.Unlock()
+
+    job.mutex.RLock()
+    finished := job.finished
+    job.mutex.RUnlock()
+    if !finished {
+        writeSidecar(download, segments)
     }
 }
 
-func (dm *DownloadManager) AddCallback(callback func(*Download)) {
-    dm.callbacks = append(dm.callbacks, callback)
+func (job *DownloadJob) stop(status DownloadStatus) {
+    job.mutex.Lock()
+    job.stopStatus = status
+    job.mutex.Unlock()
+
+    job.cancel()
+}
+
+// setQueue moves the job to another queue and its bandwidth group
+func (job *DownloadJob) setQueue(queueID int64) {
+    job.mutex.Lock()
+    job.download.QueueID = queueID
+    job.mutex.Unlock()
+
+    job.flow.SetGroup(queueID)
+}
+
+func (job *DownloadJob) queueID() int64 {
+    job.mutex.RLock()
+    defer job.mutex.RUnlock()
+
+    return job.download.QueueID
+}
+
+// stopForQueue pauses the job so that it goes back to its queue
+func (job *DownloadJob) stopForQueue() {
+    job.mutex.Lock()
+    job.requeue = true
+    job.mutex.Unlock()
+
+    job.stop(StatusPaused)
+}
+
+func (job *DownloadJob) requeued() bool {
+    job.mutex.RLock()
+    defer job.mutex.RUnlock()
+
+    return job.requeue
+}
+
+// recordRetry notes a retried failure on the download record
+func (job *DownloadJob) recordRetry(err error) {
+    job.mutex.Lock()
+    defer job.mutex.Unlock()
+
+    job.download.Retries++
+    job.download.RetryError = err.Error()
 }
 
-func (dm *DownloadManager) notifyCallbacks(download *Download) {
-    for _, callback := range dm.callbacks {
-        go callback(download)
+// sampleChunkSpeeds measures the speed of every chunk a worker is fetching
+func (job *DownloadJob) sampleChunkSpeeds(now time.Time) {
+    job.mutex.RLock()
+    defer job.mutex.RUnlock()
+
+    for _, chunk := range job.chunks {
+        chunk.sampleSpeed(now, chunk.active)
     }
 }
 
-// Rate-limited reader
-type rateLimitedReader struct {
-    reader   io.Reader
-    limiter  chan time.Time
-    maxBytes int64
+// segments returns a snapshot of the chunk layout for storage and display
+func (job *DownloadJob) segments() []*storage.Chunk {
+    job.mutex.RLock()
+    defer job.mutex.RUnlock()
+
+    if len(job.chunks) == 0 {
+        return nil
+    }
+
+    chunks := make([]*storage.Chunk, 0, len(job.chunks))
+    for _, chunk := range job.chunks {
+        chunks = append(chunks, chunk.toStorage(job.download.ID))
+    }
+    return chunks
 }
 
-func newRateLimitedReader(reader io.Reader, maxBytesPerSecond int64) *rateLimitedReader {
-    limiter := make(chan time.Time, 1)
-    
-    go func() {
-        ticker := time.NewTicker(time.Second / time.Duration(maxBytesPerSecond/1024))
-        defer ticker.Stop()
-        
-        for t := range ticker.C {
-            select {
-            case limiter <- t:
-            default:
-            }
-        }
-    }()
+func (job *DownloadJob) getStopStatus() DownloadStatus {
+    job.mutex.RLock()
+    defer job.mutex.RUnlock()
+
+    return job.stopStatus
+}
 
-    return &rateLimitedReader{
-        reader:   reader,
-        limiter:  limiter,
-        maxBytes: maxBytesPerSecond,
+// position returns the next byte to fetch and the last byte of the chunk
+func (c *ChunkDownloader) position() (int64, int64) {
+    c.mutex.RLock()
+    defer c.mutex.RUnlock()
+
+    return c.start + c.downloaded, c.end
+}
+
+func (c *ChunkDownloader) remaining() int64 {
+    c.mutex.RLock()
+    defer c.mutex.RUnlock()
+
+    return c.end - c.start + 1 - c.downloaded
+}
+
+func (c *ChunkDownloader) recordFailure(err error) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    c.attempts++
+    c.lastError = err.Error()
+}
+
+func (c *ChunkDownloader) sampleSpeed(now time.Time, active bool) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    // A chunk starts measuring afresh each time a worker takes it up
+    if !active {
+        c.speed = speedMeter{}
+        c.rate = 0
+        return
     }
+    c.rate = c.speed.sample(now, c.downloaded)
 }
 
-func (r *rateLimitedReader) Read(p []byte) (n int, err error) {
-    <-r.limiter // Wait for rate limit
-    return r.reader.Read(p)
-}
\ No newline at end of file
+func (c *ChunkDownloader) toStorage(downloadID int64) *storage.Chunk {
+    c.mutex.RLock()
+    defer c.mutex.RUnlock()
+
+    return &storage.Chunk{
+        DownloadID: downloadID,
+        Start:      c.start,
+        End:        c.end,
+        Downloaded: c.downloaded,
+        Attempts:   c.attempts,
+        LastError:  c.lastError,
+        Speed:      c.rate,
+    }
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+    "context"
+    "crypto/md5"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+// TestStartDownloadOnce starts the same download from several goroutines,
+// like a Start from the UI racing the queue, and expects one job
+func TestStartDownloadOnce(t *testing.T) {
+    release := make(chan struct{})
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-release:
+        case <-r.Context().Done():
+        }
+    }))
+    defer server.Close()
+    defer close(release)
+
+    store := storage.NewMemoryRepository()
+    dm, err := NewDownloadManager(store)
+    if err != nil {
+        t.Fatal(err)
+    }
+    id, err := store.SaveDownload(&storage.Download{
+        URL:       server.URL + "/file.bin",
+        Filename:  "file.bin",
+        Path:      t.TempDir(),
+        Status:    StatusPending,
+        CreatedAt: time.Now(),
+        Chunks:    1,
+        QueueID:   storage.MainQueueID,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    const starts = 32
+    errs := make(chan error, starts)
+    var ready sync.WaitGroup
+    ready.Add(starts)
+    for i := 0; i < starts; i++ {
+        go func() {
+            ready.Done()
+            ready.Wait()
+            errs <- dm.StartDownload(id)
+        }()
+    }
+
+    started := 0
+    for i := 0; i < starts; i++ {
+        if err := <-errs; err == nil {
+            started++
+        }
+    }
+    if started != 1 {
+        t.Errorf("%d jobs started, want 1", started)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := dm.Shutdown(ctx); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// TestProbeRangesPartialDigests probes a server that rejects HEAD, so the
+// headers come from a 206 for the first byte. Its Content-MD5 and Digest
+// are of that byte and must not become the checksum of the file.
+func TestProbeRangesPartialDigests(t *testing.T) {
+    content := []byte("the whole file")
+    firstByte := md5.Sum(content[:1])
+    whole := sha256.Sum256(content)
+
+    tests := []struct {
+        name       string
+        reprDigest string
+        algorithm  string
+        digest     string
+    }{
+        {name: "partial digests only"},
+        {
+            name:       "repr digest",
+            reprDigest: "sha-256=:" + base64.StdEncoding.EncodeToString(whole[:]) + ":",
+            algorithm:  ChecksumSHA256,
+            digest:     hex.EncodeToString(whole[:]),
+        },
+    }
+
+    for _, test := range tests {
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if r.Method == "HEAD" {
+                w.WriteHeader(http.StatusMethodNotAllowed)
+                return
+            }
+            w.Header().Set("Content-Range", "bytes 0-0/14")
+            w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(firstByte[:]))
+            w.Header().Set("Digest", "md5="+base64.StdEncoding.EncodeToString(firstByte[:]))
+            if test.reprDigest != "" {
+                w.Header().Set("Repr-Digest", test.reprDigest)
+            }
+            w.WriteHeader(http.StatusPartialContent)
+            w.Write(content[:1])
+        }))
+
+        dm, err := NewDownloadManager(storage.NewMemoryRepository())
+        if err != nil {
+            t.Fatal(err)
+        }
+        job := &DownloadJob{
+            download: &storage.Download{URL: server.URL + "/file.bin"},
+            ctx:      context.Background(),
+            client:   dm.newHTTPClient(),
+        }
+
+        header, supportsRange, err := dm.probeRanges(job)
+        server.Close()
+        if err != nil {
+            t.Fatalf("%s: %v", test.name, err)
+        }
+        if !supportsRange {
+            t.Errorf("%s: ranges not supported", test.name)
+        }
+        if size := header.Get("Content-Length"); size != "14" {
+            t.Errorf("%s: Content-Length %q, want the size of the whole file", test.name, size)
+        }
+        if algorithm, digest := serverDigest(header); algorithm != test.algorithm || digest != test.digest {
+            t.Errorf("%s: digest %s %q, want %s %q", test.name, algorithm, digest, test.algorithm, test.digest)
+        }
+    }
+}
+
+// TestStartDownloadWaitsForDependencies starts a download by hand while the
+// download it depends on has not completed
+func TestStartDownloadWaitsForDependencies(t *testing.T) {
+    store := storage.NewMemoryRepository()
+    dm, err := NewDownloadManager(store)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var ids []int64
+    for _, name := range []string{"first.bin", "second.bin"} {
+        id, err := store.SaveDownload(&storage.Download{
+            URL:       "http://example.com/" + name,
+            Filename:  name,
+            Path:      t.TempDir(),
+            Status:    StatusPaused,
+            CreatedAt: time.Now(),
+            QueueID:   storage.MainQueueID,
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        ids = append(ids, id)
+    }
+    if err := dm.AddDependency(ids[1], ids[0]); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := dm.StartDownload(ids[1]); err == nil {
+        t.Error("a download started before the one it waits for completed")
+    }
+    if dm.isRunning(ids[1]) {
+        t.Error("a job runs for a download that waits")
+    }
+}
@@ -0,0 +1,258 @@
+package core
+
+import (
+    "sync"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+// eventBuffer is how many events a subscription holds; beyond it the oldest
+// are dropped, progress events first
+const eventBuffer = 256
+
+type EventType int
+
+const (
+    EventAdded EventType = iota
+    EventQueued          // waiting in a queue again, after a pause or a block
+    EventStarted
+    EventProgress
+    EventPaused
+    EventCompleted
+    EventFailed // failed, cancelled or blocked, see Download.Status
+    EventRemoved
+    EventPackageCompleted
+)
+
+func (t EventType) String() string {
+    switch t {
+    case EventAdded:
+        return "Added"
+    case EventQueued:
+        return "Queued"
+    case EventStarted:
+        return "Started"
+    case EventProgress:
+        return "Progress"
+    case EventPaused:
+        return "Paused"
+    case EventCompleted:
+        return "Completed"
+    case EventFailed:
+        return "Failed"
+    case EventRemoved:
+        return "Removed"
+    case EventPackageCompleted:
+        return "Package completed"
+    default:
+        return "Unknown"
+    }
+}
+
+// Event tells subscribers what happened to a download. Download is a copy
+// taken when the event was published; Package is only set for
+// EventPackageCompleted.
+type Event struct {
+    Type     EventType
+    Time     time.Time
+    Download *Download
+    Package  *PackageInfo
+}
+
+func (e Event) downloadID() int64 {
+    if e.Download == nil {
+        return 0
+    }
+    return e.Download.ID
+}
+
+// Subscription receives events in the order they were published. Each one
+// has its own buffer, so a slow subscriber does not hold up the downloads
+// or the other subscribers: pending progress events of a download are
+// merged into the latest one, and once the buffer is full new progress
+// events are dropped. Other events then make room by dropping the oldest
+// progress event, or the oldest event when there is none.
+type Subscription struct {
+    handler func(Event)
+    mutex   sync.Mutex
+    ready   *sync.Cond
+    pending []Event
+    closed  bool
+}
+
+// eventBus hands every published event to all subscriptions
+type eventBus struct {
+    mutex         sync.Mutex
+    subscriptions map[*Subscription]struct{}
+    last          map[int64]EventType // latest event type of the downloads with a job
+}
+
+func newEventBus() *eventBus {
+    return &eventBus{
+        subscriptions: make(map[*Subscription]struct{}),
+        last:          make(map[int64]EventType),
+    }
+}
+
+// Subscribe calls handler for every event from now on, one at a time
+func (dm *DownloadManager) Subscribe(handler func(Event)) *Subscription {
+    sub := &Subscription{handler: handler}
+    sub.ready = sync.NewCond(&sub.mutex)
+
+    dm.events.mutex.Lock()
+    dm.events.subscriptions[sub] = struct{}{}
+    dm.events.mutex.Unlock()
+
+    go sub.run()
+    return sub
+}
+
+// Unsubscribe stops the events to a subscription. Events not delivered yet
+// are discarded.
+func (dm *DownloadManager) Unsubscribe(sub *Subscription) {
+    dm.events.mutex.Lock()
+    delete(dm.events.subscriptions, sub)
+    dm.events.mutex.Unlock()
+
+    sub.mutex.Lock()
+    sub.closed = true
+    sub.pending = nil
+    sub.mutex.Unlock()
+    sub.ready.Signal()
+}
+
+// publish sends an event about a download to all subscribers
+func (dm *DownloadManager) publish(eventType EventType, download *Download) {
+    dm.events.publish(Event{Type: eventType, Time: time.Now(), Download: copyDownload(download)})
+}
+
+// publishStatus sends the event that goes with the status of a download
+func (dm *DownloadManager) publishStatus(download *Download) {
+    dm.publish(statusEvent(download.Status), download)
+}
+
+func (dm *DownloadManager) publishPackageCompleted(info *PackageInfo) {
+    dm.events.publish(Event{Type: EventPackageCompleted, Time: time.Now(), Package: info})
+}
+
+func statusEvent(status DownloadStatus) EventType {
+    switch {
+    case status == StatusPending:
+        return EventQueued
+    case status == StatusDownloading:
+        return EventProgress
+    case status == StatusPaused:
+        return EventPaused
+    case status == StatusCompleted:
+        return EventCompleted
+    case isFailure(status):
+        return EventFailed
+    default:
+        return EventProgress
+    }
+}
+
+func (b *eventBus) publish(event Event) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+
+    // Progress measured before a download stopped must not arrive after
+    // it, and a job winding down must not bring a removed download back.
+    // Only downloads with a job are tracked, until it ends; see forget.
+    if id := event.downloadID(); id != 0 {
+        last, running := b.last[id]
+        switch {
+        case last == EventRemoved:
+            return
+        case event.Type == EventProgress && last != EventStarted && last != EventProgress:
+            return
+        case event.Type == EventStarted || running:
+            b.last[id] = event.Type
+        }
+    }
+
+    for sub := range b.subscriptions {
+        sub.push(event)
+    }
+}
+
+// forget stops tracking a download once its job has published its last
+// event
+func (b *eventBus) forget(id int64) {
+    b.mutex.Lock()
+    delete(b.last, id)
+    b.mutex.Unlock()
+}
+
+func (s *Subscription) push(event Event) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+
+    if s.closed {
+        return
+    }
+
+    if event.Type == EventProgress {
+        // Replace the pending progress of the download, if nothing else
+        // happened to it since
+        for i := len(s.pending) - 1; i >= 0; i-- {
+            if s.pending[i].downloadID() != event.downloadID() {
+                continue
+            }
+            if s.pending[i].Type == EventProgress {
+                s.pending[i] = event
+                return
+            }
+            break
+        }
+        if len(s.pending) >= eventBuffer {
+            return
+        }
+    } else if len(s.pending) >= eventBuffer {
+        s.makeRoom()
+    }
+
+    s.pending = append(s.pending, event)
+    s.ready.Signal()
+}
+
+// makeRoom drops the oldest pending progress event, or the oldest event
+// when there is none
+func (s *Subscription) makeRoom() {
+    drop := 0
+    for i, event := range s.pending {
+        if event.Type == EventProgress {
+            drop = i
+            break
+        }
+    }
+    s.pending = append(s.pending[:drop], s.pending[drop+1:]...)
+}
+
+func (s *Subscription) run() {
+    for {
+        s.mutex.Lock()
+        for len(s.pending) == 0 && !s.closed {
+            s.ready.Wait()
+        }
+        if s.closed {
+            s.mutex.Unlock()
+            return
+        }
+        event := s.pending[0]
+        s.pending[0] = Event{}
+        s.pending = s.pending[1:]
+        s.mutex.Unlock()
+
+        s.handler(event)
+    }
+}
+
+// copyDownload takes a copy subscribers can keep, unaffected by the job
+// that goes on changing the original
+func copyDownload(download *Download) *Download {
+    snapshot := *download
+    snapshot.Segments = append([]*storage.Chunk(nil), download.Segments...)
+    return &snapshot
+}
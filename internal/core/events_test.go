@@ -0,0 +1,81 @@
+package core
+
+import (
+    "reflect"
+    "sync"
+    "testing"
+)
+
+// pendingSubscription adds a subscription to bus that nothing reads from,
+// so its events stay pending
+func pendingSubscription(bus *eventBus) *Subscription {
+    sub := &Subscription{handler: func(Event) {}}
+    sub.ready = sync.NewCond(&sub.mutex)
+    bus.subscriptions[sub] = struct{}{}
+    return sub
+}
+
+func downloadEvent(eventType EventType, id int64) Event {
+    return Event{Type: eventType, Download: &Download{ID: id}}
+}
+
+func pendingTypes(sub *Subscription) []EventType {
+    var types []EventType
+    for _, event := range sub.pending {
+        types = append(types, event.Type)
+    }
+    return types
+}
+
+// TestEventBusRemoved checks that a job winding down after its download was
+// removed cannot bring it back, and that nothing is kept once it ended
+func TestEventBusRemoved(t *testing.T) {
+    bus := newEventBus()
+    sub := pendingSubscription(bus)
+
+    // Removed while its job runs
+    bus.publish(downloadEvent(EventStarted, 1))
+    bus.publish(downloadEvent(EventProgress, 1))
+    bus.publish(downloadEvent(EventRemoved, 1))
+    bus.publish(downloadEvent(EventProgress, 1))
+    bus.publish(downloadEvent(EventFailed, 1))
+    bus.forget(1)
+
+    // Removed without a job
+    bus.publish(downloadEvent(EventAdded, 2))
+    bus.publish(downloadEvent(EventRemoved, 2))
+    bus.publish(downloadEvent(EventProgress, 2))
+
+    want := []EventType{EventStarted, EventProgress, EventRemoved, EventAdded, EventRemoved}
+    if got := pendingTypes(sub); !reflect.DeepEqual(got, want) {
+        t.Errorf("delivered %v, want %v", got, want)
+    }
+    if len(bus.last) != 0 {
+        t.Errorf("%d downloads still tracked after their jobs ended", len(bus.last))
+    }
+}
+
+// TestSubscriptionBuffer fills the buffer of a subscriber that does not keep
+// up with events that are not progress
+func TestSubscriptionBuffer(t *testing.T) {
+    bus := newEventBus()
+    sub := pendingSubscription(bus)
+
+    bus.publish(downloadEvent(EventStarted, 1))
+    bus.publish(downloadEvent(EventProgress, 1))
+    for id := int64(2); id < 2*eventBuffer; id++ {
+        bus.publish(downloadEvent(EventQueued, id))
+    }
+
+    if len(sub.pending) != eventBuffer {
+        t.Fatalf("%d events pending, want at most %d", len(sub.pending), eventBuffer)
+    }
+    for _, event := range sub.pending {
+        if event.Type == EventProgress {
+            t.Error("the progress event was kept over newer events")
+        }
+    }
+    if last := sub.pending[len(sub.pending)-1].downloadID(); last != 2*eventBuffer-1 {
+        t.Errorf("the newest pending event is of download %d, want %d", last, 2*eventBuffer-1)
+    }
+}
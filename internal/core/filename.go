@@ -0,0 +1,259 @@
+package core
+
+import (
+    "mime"
+    "net/http"
+    "net/url"
+    "path"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+const (
+    defaultFilename   = "download"
+    maxFilenameLength = 255 // bytes, the common limit of desktop file systems
+)
+
+// resolveFilename picks the name a download is saved under: the
+// Content-Disposition filename, then the last path segment of the final URL
+// after redirects, then a generic name with an extension for the MIME type.
+// The result is always a plain, sanitized file name.
+func resolveFilename(resp *http.Response, rawURL string) string {
+    if resp != nil {
+        if name := sanitizeFilename(filenameFromDisposition(resp.Header.Get("Content-Disposition"))); name != "" {
+            return name
+        }
+
+        if resp.Request != nil && resp.Request.URL != nil {
+            if name := sanitizeFilename(filenameFromURL(resp.Request.URL)); name != "" {
+                return name
+            }
+        }
+    }
+
+    if u, err := url.Parse(rawURL); err == nil {
+        if name := sanitizeFilename(filenameFromURL(u)); name != "" {
+            return name
+        }
+    }
+
+    if resp != nil {
+        return defaultFilename + extensionForType(resp.Header.Get("Content-Type"))
+    }
+    return defaultFilename
+}
+
+// filenameFromDisposition extracts the file name from a Content-Disposition
+// header as described by RFC 6266. An RFC 5987 encoded filename* parameter
+// wins over the plain filename parameter.
+func filenameFromDisposition(header string) string {
+    if header == "" {
+        return ""
+    }
+
+    params := parseHeaderParams(header)
+    if value, ok := params["filename*"]; ok {
+        if name, ok := decodeExtValue(value); ok && name != "" {
+            return name
+        }
+    }
+
+    return params["filename"]
+}
+
+// parseHeaderParams splits the parameters after the first ';' of a header
+// value. Parameter names are lower-cased, quoted values are unquoted. It is
+// lenient with the unquoted spaces many servers send.
+func parseHeaderParams(header string) map[string]string {
+    params := make(map[string]string)
+
+    i := strings.IndexByte(header, ';')
+    if i < 0 {
+        return params
+    }
+    rest := header[i+1:]
+
+    for len(rest) > 0 {
+        rest = strings.TrimLeft(rest, " \t;")
+        if rest == "" {
+            break
+        }
+
+        eq := strings.IndexAny(rest, "=;")
+        if eq < 0 || rest[eq] == ';' {
+            // Parameter without a value, skip it
+            if eq < 0 {
+                break
+            }
+            rest = rest[eq+1:]
+            continue
+        }
+
+        name := strings.ToLower(strings.TrimSpace(rest[:eq]))
+        rest = strings.TrimLeft(rest[eq+1:], " \t")
+
+        var value string
+        if strings.HasPrefix(rest, `"`) {
+            value, rest = readQuotedString(rest[1:])
+        } else {
+            end := strings.IndexByte(rest, ';')
+            if end < 0 {
+                end = len(rest)
+            }
+            value, rest = strings.TrimSpace(rest[:end]), rest[end:]
+        }
+
+        if _, seen := params[name]; !seen {
+            params[name] = value
+        }
+    }
+
+    return params
+}
+
+// readQuotedString reads a quoted-string whose opening quote was already
+// consumed and returns its unescaped value and the remaining input
+func readQuotedString(s string) (string, string) {
+    var b strings.Builder
+
+    for i := 0; i < len(s); i++ {
+        switch s[i] {
+        case '\\':
+            if i+1 < len(s) {
+                i++
+                b.WriteByte(s[i])
+            }
+        case '"':
+            return b.String(), s[i+1:]
+        default:
+            b.WriteByte(s[i])
+        }
+    }
+
+    // Unterminated quote, take everything
+    return b.String(), ""
+}
+
+// decodeExtValue decodes an RFC 5987 ext-value such as UTF-8''na%C3%AFve.txt.
+// Only UTF-8 and ISO-8859-1, the charsets RFC 6266 requires, are supported.
+func decodeExtValue(value string) (string, bool) {
+    parts := strings.SplitN(value, "'", 3)
+    if len(parts) != 3 {
+        return "", false
+    }
+
+    raw, err := percentDecode(parts[2])
+    if err != nil {
+        return "", false
+    }
+
+    switch strings.ToLower(parts[0]) {
+    case "utf-8":
+        if !utf8.Valid(raw) {
+            return "", false
+        }
+        return string(raw), true
+    case "iso-8859-1":
+        runes := make([]rune, len(raw))
+        for i, c := range raw {
+            runes[i] = rune(c)
+        }
+        return string(runes), true
+    default:
+        return "", false
+    }
+}
+
+// percentDecode undoes %XX escapes without treating '+' as a space
+func percentDecode(s string) ([]byte, error) {
+    decoded, err := url.PathUnescape(s)
+    if err != nil {
+        return nil, err
+    }
+    return []byte(decoded), nil
+}
+
+func filenameFromURL(u *url.URL) string {
+    name := path.Base(u.Path)
+    if name == "." || name == "/" {
+        return ""
+    }
+    return name
+}
+
+func extensionForType(contentType string) string {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil || mediaType == "" {
+        return ""
+    }
+
+    exts, err := mime.ExtensionsByType(mediaType)
+    if err != nil || len(exts) == 0 {
+        return ""
+    }
+
+    // Prefer the extension that matches the subtype, e.g. .html over .htm
+    subtype := mediaType[strings.IndexByte(mediaType, '/')+1:]
+    for _, ext := range exts {
+        if ext[1:] == subtype {
+            return ext
+        }
+    }
+    return exts[0]
+}
+
+var windowsReservedNames = map[string]bool{
+    "CON": true, "PRN": true, "AUX": true, "NUL": true,
+    "COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+    "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+    "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+    "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFilename turns an untrusted name into a single path element that
+// cannot escape the download directory. It returns "" when nothing usable
+// is left.
+func sanitizeFilename(name string) string {
+    // Only keep the last element of anything that looks like a path
+    if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+        name = name[i+1:]
+    }
+
+    name = strings.Map(func(r rune) rune {
+        switch {
+        case r == utf8.RuneError, unicode.IsControl(r):
+            return -1
+        case strings.ContainsRune(`<>:"|?*`, r):
+            return '_'
+        }
+        return r
+    }, name)
+
+    name = strings.Trim(name, " .")
+    if name == "" {
+        return ""
+    }
+
+    base := name
+    if i := strings.IndexByte(base, '.'); i >= 0 {
+        base = base[:i]
+    }
+    if windowsReservedNames[strings.ToUpper(base)] {
+        name = "_" + name
+    }
+
+    if len(name) > maxFilenameLength {
+        ext := path.Ext(name)
+        if len(ext) > 16 {
+            ext = ""
+        }
+        stem := name[:maxFilenameLength-len(ext)]
+        for !utf8.ValidString(stem) {
+            stem = stem[:len(stem)-1]
+        }
+        name = stem + ext
+    }
+
+    return name
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync/atomic"
+
+    "idm-go/internal/storage"
+)
+
+// errRemoteChanged is returned when the server answered a resumed range
+// request with the whole, changed file
+var errRemoteChanged = errors.New("remote file changed since the download started")
+
+// digestAlgorithms maps the algorithm names of Repr-Digest (RFC 9530) and
+// Digest (RFC 3230) to ours
+var digestAlgorithms = map[string]string{
+    "sha-512":    ChecksumSHA512,
+    "id-sha-512": ChecksumSHA512,
+    "sha-256":    ChecksumSHA256,
+    "id-sha-256": ChecksumSHA256,
+    "sha":        ChecksumSHA1,
+    "md5":        ChecksumMD5,
+}
+
+// serverDigest returns the strongest digest of the full file announced in
+// the Repr-Digest, Digest or Content-MD5 headers, as lower-case hex
+func serverDigest(header http.Header) (string, string) {
+    digests := make(map[string]string)
+
+    // Repr-Digest is a structured field dictionary: sha-256=:<base64>:
+    for name, value := range parseDigestList(header.Values("Repr-Digest")) {
+        if algorithm, ok := digestAlgorithms[name]; ok && strings.HasPrefix(value, ":") && strings.HasSuffix(value, ":") && len(value) > 1 {
+            if digest := decodeBase64Digest(algorithm, value[1:len(value)-1]); digest != "" {
+                digests[algorithm] = digest
+            }
+        }
+    }
+
+    // The older Digest header has plain base64 values: SHA-256=<base64>
+    for name, value := range parseDigestList(header.Values("Digest")) {
+        if algorithm, ok := digestAlgorithms[name]; ok && digests[algorithm] == "" {
+            if digest := decodeBase64Digest(algorithm, value); digest != "" {
+                digests[algorithm] = digest
+            }
+        }
+    }
+
+    if value := header.Get("Content-MD5"); value != "" && digests[ChecksumMD5] == "" {
+        if digest := decodeBase64Digest(ChecksumMD5, strings.TrimSpace(value)); digest != "" {
+            digests[ChecksumMD5] = digest
+        }
+    }
+
+    for _, algorithm := range ChecksumAlgorithms {
+        if digest := digests[algorithm]; digest != "" {
+            return algorithm, digest
+        }
+    }
+    return "", ""
+}
+
+// parseDigestList splits "name=value, name=value" lists into lower-case names
+// and their values, dropping any ";param" suffix
+func parseDigestList(values []string) map[string]string {
+    result := make(map[string]string)
+
+    for _, value := range values {
+        for _, member := range strings.Split(value, ",") {
+            name, val, ok := strings.Cut(strings.TrimSpace(member), "=")
+            if !ok {
+                continue
+            }
+            if i := strings.IndexByte(val, ';'); i >= 0 {
+                val = val[:i]
+            }
+            result[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(val)
+        }
+    }
+
+    return result
+}
+
+func decodeBase64Digest(algorithm, value string) string {
+    raw, err := base64.StdEncoding.DecodeString(value)
+    if err != nil {
+        return ""
+    }
+
+    _, digest, err := NormalizeChecksum(algorithm, hex.EncodeToString(raw))
+    if err != nil {
+        return ""
+    }
+    return digest
+}
+
+// ifRangeValue returns the validator for If-Range. Only a strong ETag may be
+// used, Last-Modified is the fallback.
+func ifRangeValue(download *storage.Download) string {
+    if download.ETag != "" && !strings.HasPrefix(download.ETag, "W/") {
+        return download.ETag
+    }
+    return download.LastModified
+}
+
+// remoteChanged compares the validators of a fresh response with the ones
+// stored when the download was added
+func remoteChanged(download *storage.Download, header http.Header) bool {
+    if etag := header.Get("ETag"); etag != "" && download.ETag != "" {
+        return etag != download.ETag
+    }
+    if modified := header.Get("Last-Modified"); modified != "" && download.LastModified != "" {
+        return modified != download.LastModified
+    }
+    return false
+}
+
+// applyRemoteInfo records size and validators of a response on the download,
+// and its digest unless the download already has one
+func applyRemoteInfo(download *storage.Download, header http.Header) {
+    if size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && size > 0 {
+        download.Size = size
+    }
+    download.ETag = header.Get("ETag")
+    download.LastModified = header.Get("Last-Modified")
+
+    if download.Checksum == "" {
+        if algorithm, digest := serverDigest(header); digest != "" {
+            download.ChecksumAlgorithm = algorithm
+            download.Checksum = digest
+            download.ChecksumSource = storage.ChecksumFromServer
+        }
+    }
+}
+
+// discardPartialData throws away everything fetched so far after the remote
+// file changed, and takes over the new size, validators and digest
+func (dm *DownloadManager) discardPartialData(job *DownloadJob, header http.Header) {
+    download := job.download
+
+    job.mutex.Lock()
+    job.chunks = nil
+    job.mutex.Unlock()
+
+    dm.progress.Forget(download.ID)
+    dm.store.DeleteChunks(download.ID)
+    removePartialFiles(download)
+
+    job.mutex.Lock()
+    atomic.StoreInt64(&job.downloaded, 0)
+    download.Downloaded = 0
+    download.Progress = 0
+    replaceRemoteInfo(download, header)
+    job.mutex.Unlock()
+
+    job.mutex.RLock()
+    dm.updateDownload(download)
+    job.mutex.RUnlock()
+}
+
+// replaceRemoteInfo switches the download over to a changed remote file
+func replaceRemoteInfo(download *storage.Download, header http.Header) {
+    // A digest announced for the old file no longer applies
+    if download.ChecksumSource == storage.ChecksumFromServer {
+        download.ChecksumAlgorithm = ""
+        download.Checksum = ""
+        download.ChecksumSource = ""
+    }
+    applyRemoteInfo(download, header)
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+var errShuttingDown = errors.New("download manager is shutting down")
+
+// shutdownSaveTimeout bounds the last write of progress when Shutdown runs
+// out of time, in case the database is what holds things up
+const shutdownSaveTimeout = 2 * time.Second
+
+// Start runs the queues, the statistics and the schedules in the background
+// until ctx is cancelled or Shutdown is called
+func (dm *DownloadManager) Start(ctx context.Context) error {
+    dm.mutex.Lock()
+    defer dm.mutex.Unlock()
+
+    if dm.closing {
+        return errShuttingDown
+    }
+    if dm.stopLoops != nil {
+        return fmt.Errorf("download manager already started")
+    }
+
+    ctx, dm.stopLoops = context.WithCancel(ctx)
+    loops := []func(context.Context){
+        dm.processQueue,
+        dm.updateStats,
+        dm.progress.Run,
+        dm.runBandwidthSchedule,
+        dm.runQueueScheduler,
+    }
+    for _, loop := range loops {
+        dm.loops.Add(1)
+        go func(loop func(context.Context)) {
+            defer dm.loops.Done()
+            loop(ctx)
+        }(loop)
+    }
+
+    return nil
+}
+
+// Shutdown stops the background work and pauses the running downloads. It
+// returns once their state is saved, so the database can be closed, or
+// with an error when ctx ends first. Downloads it interrupts are queued
+// again on the next start if DownloadConfig.AutoResume is set.
+func (dm *DownloadManager) Shutdown(ctx context.Context) error {
+    dm.mutex.Lock()
+    dm.closing = true
+    stopLoops := dm.stopLoops
+    jobs := make([]*DownloadJob, 0, len(dm.downloads))
+    for _, job := range dm.downloads {
+        jobs = append(jobs, job)
+    }
+    dm.mutex.Unlock()
+
+    // No new downloads are started from here on. The running ones are told
+    // to stop right away, not only once the loops are done.
+    if stopLoops != nil {
+        stopLoops()
+    }
+    for _, job := range jobs {
+        if dm.Config().AutoResume {
+            job.stopForQueue()
+        } else {
+            job.stop(StatusPaused)
+        }
+    }
+
+    loopsErr := waitFor(ctx, &dm.loops)
+    jobsErr := waitFor(ctx, &dm.jobs)
+    if loopsErr == nil && jobsErr == nil {
+        return nil
+    }
+
+    // Downloads that did not stop in time keep the progress they made, the
+    // flush of progress.Run may not have happened
+    saved := make(chan struct{})
+    go func() {
+        dm.saveUnfinished()
+        close(saved)
+    }()
+    select {
+    case <-saved:
+    case <-time.After(shutdownSaveTimeout):
+    }
+
+    if loopsErr != nil {
+        return fmt.Errorf("stopping background work: %w", loopsErr)
+    }
+    return fmt.Errorf("stopping downloads: %w", jobsErr)
+}
+
+// saveUnfinished records and writes the progress of the downloads still
+// running. They stay Downloading in the database, so the next start treats
+// them as interrupted.
+func (dm *DownloadManager) saveUnfinished() error {
+    dm.mutex.RLock()
+    for _, job := range dm.downloads {
+        segments := job.segments()
+
+        job.mutex.Lock()
+        if !job.finished {
+            download := job.download
+            downloaded := atomic.LoadInt64(&job.downloaded)
+            var progress float64
+            if download.Size > 0 {
+                progress = float64(downloaded) / float64(download.Size) * 100
+            }
+            dm.progress.Record(&storage.Progress{
+                DownloadID: download.ID,
+                Downloaded: downloaded,
+                Progress:   progress,
+                Chunks:     segments,
+            })
+        }
+        job.mutex.Unlock()
+    }
+    dm.mutex.RUnlock()
+
+    return dm.progress.Flush()
+}
+
+func (dm *DownloadManager) isClosing() bool {
+    dm.mutex.RLock()
+    defer dm.mutex.RUnlock()
+
+    return dm.closing
+}
+
+// waitFor waits for a wait group, or until ctx ends
+func waitFor(ctx context.Context, wg *sync.WaitGroup) error {
+    done := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        // Done at the last moment still counts
+        select {
+        case <-done:
+            return nil
+        default:
+            return ctx.Err()
+        }
+    }
+}
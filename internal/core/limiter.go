@@ -0,0 +1,398 @@
+package core
+
+import (
+    "context"
+    "math"
+    "sync"
+    "time"
+)
+
+const (
+    // limiterBurst is how much unused bandwidth may be saved up, in seconds
+    // of the current rate
+    limiterBurst = 0.25
+
+    // minLimitedRead keeps reads from getting silly small at very low rates
+    minLimitedRead = 512
+)
+
+// BandwidthLimiter shares a global rate between the jobs registered with it.
+// Each job reads through its own Flow, a token bucket whose rate is the
+// job's share of the global limit, in proportion to its weight and capped
+// by its own limit. Flows belong to a group, one per queue, which can be
+// capped as a whole. Limits and weights can be changed at any time and
+// waiting readers pick them up immediately. Tokens a slow or stalled flow
+// cannot use are lent to the others, so together they use the whole rate.
+type BandwidthLimiter struct {
+    mutex      sync.Mutex
+    rate       float64 // bytes per second, 0 for unlimited
+    flows      map[*Flow]struct{}
+    groups     map[int64]float64 // group caps in bytes per second
+    spare      float64           // unused tokens of flows in uncapped groups
+    groupSpare map[int64]float64 // unused tokens of flows in capped groups
+    changed    chan struct{}     // closed when any rate changes
+}
+
+// Flow is the bandwidth of one job
+type Flow struct {
+    limiter *BandwidthLimiter
+    group   int64
+    weight  int
+    limit   float64 // own cap in bytes per second, 0 for none
+    rate    float64 // allocated rate, 0 for unlimited
+    tokens  float64
+    last    time.Time
+}
+
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+    return &BandwidthLimiter{
+        rate:       float64(clampRate(bytesPerSecond)),
+        flows:      make(map[*Flow]struct{}),
+        groups:     make(map[int64]float64),
+        groupSpare: make(map[int64]float64),
+        changed:    make(chan struct{}),
+    }
+}
+
+func clampRate(bytesPerSecond int64) int64 {
+    if bytesPerSecond < 0 {
+        return 0
+    }
+    return bytesPerSecond
+}
+
+func clampWeight(weight int) int {
+    if weight < 1 {
+        return 1
+    }
+    return weight
+}
+
+// SetLimit changes the global rate in bytes per second, 0 for unlimited
+func (l *BandwidthLimiter) SetLimit(bytesPerSecond int64) {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+
+    rate := float64(clampRate(bytesPerSecond))
+    if rate == l.rate {
+        return
+    }
+    l.rate = rate
+    l.allocate()
+}
+
+// Limit returns the global rate in bytes per second, 0 for unlimited
+func (l *BandwidthLimiter) Limit() int64 {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+
+    return int64(l.rate)
+}
+
+// Register adds a flow of a group taking part in the global rate. Close it
+// when the job stops transferring so its share goes to the others.
+func (l *BandwidthLimiter) Register(group int64, weight int, bytesPerSecond int64) *Flow {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+
+    f := &Flow{
+        limiter: l,
+        group:   group,
+        weight:  clampWeight(weight),
+        limit:   float64(clampRate(bytesPerSecond)),
+        last:    time.Now(),
+    }
+    l.flows[f] = struct{}{}
+    l.allocate()
+    return f
+}
+
+// allocate splits the global rate between the groups in proportion to the
+// weights of their flows, then each group's rate between its flows. Must
+// hold l.mutex.
+func (l *BandwidthLimiter) allocate() {
+    now := time.Now()
+    groups := make(map[int64][]*Flow)
+    for f := range l.flows {
+        f.refill(now)
+        groups[f.group] = append(groups[f.group], f)
+    }
+    l.spare = math.Min(l.spare, l.rate*limiterBurst)
+
+    groupShares := make(map[int64]*share, len(groups))
+    for id, flows := range groups {
+        gs := &share{limit: l.groups[id]}
+        var flowLimits float64
+        capped := true
+        for _, f := range flows {
+            gs.weight += float64(f.weight)
+            if f.limit > 0 {
+                flowLimits += f.limit
+            } else {
+                capped = false
+            }
+        }
+
+        // A group whose flows all have their own limit cannot use more
+        // than their sum
+        if capped && (gs.limit <= 0 || flowLimits < gs.limit) {
+            gs.limit = flowLimits
+        }
+        groupShares[id] = gs
+    }
+
+    shares := make([]*share, 0, len(groupShares))
+    for _, gs := range groupShares {
+        shares = append(shares, gs)
+    }
+    waterFill(l.rate, shares)
+
+    for id, flows := range groups {
+        flowShares := make([]*share, len(flows))
+        for i, f := range flows {
+            flowShares[i] = &share{weight: float64(f.weight), limit: f.limit}
+        }
+        waterFill(groupShares[id].rate, flowShares)
+
+        for i, f := range flows {
+            f.setRate(flowShares[i].rate)
+        }
+    }
+
+    close(l.changed)
+    l.changed = make(chan struct{})
+}
+
+// share is a claim on bandwidth handed to waterFill
+type share struct {
+    weight float64
+    limit  float64 // 0 for none
+    rate   float64 // the result, 0 for unlimited
+}
+
+// waterFill splits total between the shares in proportion to their weights.
+// A share whose limit is below its part keeps to its limit and the rest is
+// split again between the others. A total of 0 means unlimited, so every
+// share simply gets its limit.
+func waterFill(total float64, shares []*share) {
+    if total <= 0 {
+        for _, s := range shares {
+            s.rate = s.limit
+        }
+        return
+    }
+
+    pending := append([]*share(nil), shares...)
+    available := total
+    for len(pending) > 0 {
+        var totalWeight float64
+        for _, s := range pending {
+            totalWeight += s.weight
+        }
+
+        budget := available
+        capped := false
+        rest := pending[:0]
+        for _, s := range pending {
+            part := budget * s.weight / totalWeight
+            if s.limit > 0 && s.limit <= part {
+                s.rate = s.limit
+                available -= s.limit
+                capped = true
+            } else {
+                rest = append(rest, s)
+            }
+        }
+        pending = rest
+
+        if !capped {
+            for _, s := range pending {
+                // Never 0, which would mean unlimited
+                s.rate = math.Max(available*s.weight/totalWeight, 1)
+            }
+            return
+        }
+    }
+}
+
+// SetGroupLimit caps the rate of all flows of a group together, in bytes per
+// second, 0 for no cap
+func (l *BandwidthLimiter) SetGroupLimit(group int64, bytesPerSecond int64) {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+
+    if rate := float64(clampRate(bytesPerSecond)); rate > 0 {
+        l.groups[group] = rate
+    } else {
+        delete(l.groups, group)
+    }
+    delete(l.groupSpare, group)
+    l.allocate()
+}
+
+// SetGroup moves the flow to another group
+func (f *Flow) SetGroup(group int64) {
+    f.limiter.mutex.Lock()
+    defer f.limiter.mutex.Unlock()
+
+    f.group = group
+    f.limiter.allocate()
+}
+
+// SetLimit changes the flow's own cap in bytes per second, 0 for none
+func (f *Flow) SetLimit(bytesPerSecond int64) {
+    f.limiter.mutex.Lock()
+    defer f.limiter.mutex.Unlock()
+
+    f.limit = float64(clampRate(bytesPerSecond))
+    f.limiter.allocate()
+}
+
+// SetWeight changes the flow's share relative to the other flows
+func (f *Flow) SetWeight(weight int) {
+    f.limiter.mutex.Lock()
+    defer f.limiter.mutex.Unlock()
+
+    f.weight = clampWeight(weight)
+    f.limiter.allocate()
+}
+
+// Rate returns the rate currently allocated to the flow, 0 for unlimited
+func (f *Flow) Rate() int64 {
+    f.limiter.mutex.Lock()
+    defer f.limiter.mutex.Unlock()
+
+    return int64(f.rate)
+}
+
+// Close hands the flow's share back to the other flows
+func (f *Flow) Close() {
+    f.limiter.mutex.Lock()
+    defer f.limiter.mutex.Unlock()
+
+    if _, ok := f.limiter.flows[f]; !ok {
+        return
+    }
+    delete(f.limiter.flows, f)
+    f.limiter.allocate()
+}
+
+// ReadSize returns how many bytes of a buffer of size n a reader should ask
+// for, so that a low limit results in a steady flow instead of bursts
+func (f *Flow) ReadSize(n int) int {
+    f.limiter.mutex.Lock()
+    defer f.limiter.mutex.Unlock()
+
+    if f.rate <= 0 {
+        return n
+    }
+
+    size := int(f.rate / 20) // about 50ms worth of data
+    if size < minLimitedRead {
+        size = minLimitedRead
+    }
+    if size > n {
+        size = n
+    }
+    return size
+}
+
+// WaitN accounts for n received bytes, waiting first if the bucket is in debt
+func (f *Flow) WaitN(ctx context.Context, n int) error {
+    l := f.limiter
+    for {
+        l.mutex.Lock()
+        if f.rate <= 0 {
+            l.mutex.Unlock()
+            return nil
+        }
+
+        l.refill(time.Now())
+        if f.tokens < 0 {
+            f.tokens += l.takeSpare(f, -f.tokens)
+        }
+        if f.tokens >= 0 {
+            f.tokens -= float64(n)
+            l.mutex.Unlock()
+            return nil
+        }
+
+        wait := time.Duration(-f.tokens / f.rate * float64(time.Second))
+        changed := l.changed
+        l.mutex.Unlock()
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return ctx.Err()
+        case <-changed:
+            timer.Stop()
+        case <-timer.C:
+        }
+    }
+}
+
+// setRate changes the allocated rate. Must hold the limiter mutex and have
+// refilled the bucket.
+func (f *Flow) setRate(rate float64) {
+    if f.rate <= 0 {
+        f.tokens = 0 // debt from before an unlimited spell is forgiven
+    }
+    f.rate = rate
+    if burst := f.rate * limiterBurst; f.tokens > burst {
+        f.tokens = burst
+    }
+}
+
+// refill adds the tokens earned since the last call. Those beyond the burst
+// go to the spare tokens, see takeSpare. Must hold the limiter mutex.
+func (f *Flow) refill(now time.Time) {
+    elapsed := now.Sub(f.last).Seconds()
+    f.last = now
+    if elapsed <= 0 || f.rate <= 0 {
+        return
+    }
+
+    f.tokens += elapsed * f.rate
+    if burst := f.rate * limiterBurst; f.tokens > burst {
+        f.limiter.addSpare(f.group, f.tokens-burst)
+        f.tokens = burst
+    }
+}
+
+// refill refills every flow, so the tokens of flows that are not reading
+// become spare. Must hold l.mutex.
+func (l *BandwidthLimiter) refill(now time.Time) {
+    for f := range l.flows {
+        f.refill(now)
+    }
+}
+
+// addSpare keeps tokens a flow of group could not use, up to a burst of the
+// rate they were allocated from. Flows of a capped group only lend to each
+// other, or the group would exceed its cap. Must hold l.mutex.
+func (l *BandwidthLimiter) addSpare(group int64, tokens float64) {
+    if rate, capped := l.groups[group]; capped {
+        l.groupSpare[group] = math.Min(l.groupSpare[group]+tokens, rate*limiterBurst)
+        return
+    }
+    l.spare = math.Min(l.spare+tokens, l.rate*limiterBurst)
+}
+
+// takeSpare lends up to n spare tokens to a flow in debt. A flow with a
+// limit of its own keeps to its allocated rate. Must hold l.mutex.
+func (l *BandwidthLimiter) takeSpare(f *Flow, n float64) float64 {
+    if f.limit > 0 {
+        return 0
+    }
+
+    if _, capped := l.groups[f.group]; capped {
+        taken := math.Min(l.groupSpare[f.group], n)
+        l.groupSpare[f.group] -= taken
+        return taken
+    }
+    taken := math.Min(l.spare, n)
+    l.spare -= taken
+    return taken
+}
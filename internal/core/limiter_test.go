@@ -0,0 +1,114 @@
+package core
+
+import (
+    "context"
+    "math"
+    "testing"
+    "time"
+)
+
+func TestWaterFill(t *testing.T) {
+    tests := []struct {
+        name   string
+        total  float64
+        shares []share // weight and limit
+        want   []float64
+    }{
+        {"by weight", 300, []share{{weight: 1}, {weight: 2}}, []float64{100, 200}},
+        {"capped share", 300, []share{{weight: 1, limit: 50}, {weight: 1}, {weight: 1}}, []float64{50, 125, 125}},
+        {"all capped", 300, []share{{weight: 1, limit: 50}, {weight: 1, limit: 100}}, []float64{50, 100}},
+        {"cap above part", 300, []share{{weight: 1, limit: 200}, {weight: 1}}, []float64{150, 150}},
+        {"unlimited", 0, []share{{weight: 1, limit: 50}, {weight: 1}}, []float64{50, 0}},
+    }
+
+    for _, test := range tests {
+        shares := make([]*share, len(test.shares))
+        for i := range test.shares {
+            shares[i] = &share{weight: test.shares[i].weight, limit: test.shares[i].limit}
+        }
+        waterFill(test.total, shares)
+
+        for i, s := range shares {
+            if math.Abs(s.rate-test.want[i]) > 0.001 {
+                t.Errorf("%s: share %d gets %.1f, want %.1f", test.name, i, s.rate, test.want[i])
+            }
+        }
+    }
+}
+
+func TestBandwidthLimiterAllocation(t *testing.T) {
+    l := NewBandwidthLimiter(3000)
+    a := l.Register(1, 1, 0)
+    b := l.Register(1, 2, 0)
+    c := l.Register(2, 1, 500)
+
+    // c keeps to its limit, a and b split the rest by weight
+    if a.Rate() != 833 || b.Rate() != 1666 || c.Rate() != 500 {
+        t.Errorf("rates %d, %d, %d, want 833, 1666, 500", a.Rate(), b.Rate(), c.Rate())
+    }
+
+    l.SetGroupLimit(1, 1200)
+    if a.Rate() != 400 || b.Rate() != 800 || c.Rate() != 500 {
+        t.Errorf("rates with group 1 capped %d, %d, %d, want 400, 800, 500", a.Rate(), b.Rate(), c.Rate())
+    }
+
+    b.Close()
+    if a.Rate() != 1200 {
+        t.Errorf("rate after the other flow closed %d, want the group cap 1200", a.Rate())
+    }
+}
+
+func TestFlowRefill(t *testing.T) {
+    l := NewBandwidthLimiter(1000)
+    f := l.Register(1, 1, 0)
+
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+
+    start := f.last
+    f.tokens = -100
+    f.refill(start.Add(100 * time.Millisecond))
+    if math.Abs(f.tokens) > 0.001 {
+        t.Errorf("tokens after 100ms in debt %.1f, want 0", f.tokens)
+    }
+
+    // Saved up to the burst, the rest is spare
+    f.refill(start.Add(2 * time.Second))
+    if f.tokens != 1000*limiterBurst {
+        t.Errorf("tokens after a long pause %.1f, want the burst %.1f", f.tokens, 1000*limiterBurst)
+    }
+    if l.spare != 1000*limiterBurst {
+        t.Errorf("spare tokens %.1f, want the global burst %.1f", l.spare, 1000*limiterBurst)
+    }
+}
+
+// TestFlowUsesSpareTokens lets one flow stall while the other is in debt:
+// the stalled flow's share must not go to waste
+func TestFlowUsesSpareTokens(t *testing.T) {
+    l := NewBandwidthLimiter(2000)
+    stalled := l.Register(1, 1, 0)
+    busy := l.Register(1, 1, 0)
+    limited := l.Register(1, 1, 0)
+    limited.SetLimit(500)
+
+    l.mutex.Lock()
+    stalled.last = stalled.last.Add(-time.Second)
+    busy.tokens = -200 // over 250ms at its rate of 750
+    l.mutex.Unlock()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    if err := busy.WaitN(ctx, 1); err != nil {
+        t.Fatalf("the busy flow waited although the stalled one left tokens: %v", err)
+    }
+
+    // A flow with its own limit does not borrow
+    l.mutex.Lock()
+    limited.tokens = -100
+    l.mutex.Unlock()
+    ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    if err := limited.WaitN(ctx, 1); err == nil {
+        t.Error("a flow borrowed beyond its own limit")
+    }
+}
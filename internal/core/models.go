@@ -8,15 +8,33 @@ import (
 // Use storage types
 type Download = storage.Download
 type DownloadStatus = storage.DownloadStatus
+type ConflictPolicy = storage.ConflictPolicy
+type Priority = storage.Priority
 
 // Re-export constants
 const (
-    StatusPending     = storage.StatusPending
-    StatusDownloading = storage.StatusDownloading
-    StatusPaused      = storage.StatusPaused
-    StatusCompleted   = storage.StatusCompleted
-    StatusFailed      = storage.StatusFailed
-    StatusCancelled   = storage.StatusCancelled
+    StatusPending            = storage.StatusPending
+    StatusDownloading        = storage.StatusDownloading
+    StatusPaused             = storage.StatusPaused
+    StatusCompleted          = storage.StatusCompleted
+    StatusFailed             = storage.StatusFailed
+    StatusCancelled          = storage.StatusCancelled
+    StatusVerificationFailed = storage.StatusVerificationFailed
+    StatusBlocked            = storage.StatusBlocked
+)
+
+const (
+    PriorityLow    = storage.PriorityLow
+    PriorityNormal = storage.PriorityNormal
+    PriorityHigh   = storage.PriorityHigh
+)
+
+const (
+    ConflictDefault   = storage.ConflictDefault
+    ConflictRename    = storage.ConflictRename
+    ConflictOverwrite = storage.ConflictOverwrite
+    ConflictSkip      = storage.ConflictSkip
+    ConflictResume    = storage.ConflictResume
 )
 
 type DownloadConfig struct {
@@ -26,6 +44,24 @@ type DownloadConfig struct {
     RetryAttempts          int
     UserAgent              string
     Timeout                time.Duration
+    ConflictPolicy         ConflictPolicy // what to do when the target file exists
+    DiscoverChecksums      bool           // look for .sha256 or SHA256SUMS files next to the URL
+    BandwidthSchedule      *BandwidthSchedule // limits by time of day, nil for MaxSpeed around the clock
+    AutoResume             bool           // queue downloads interrupted by a restart instead of pausing them
+}
+
+// DownloadOptions holds the per-download choices made when adding a download
+type DownloadOptions struct {
+    Chunks            int            // 0 uses the default
+    ConflictPolicy    ConflictPolicy // ConflictDefault uses DownloadConfig.ConflictPolicy
+    ChecksumAlgorithm string         // guessed from the digest length when empty
+    Checksum          string         // expected hex digest, empty to skip or discover
+    SpeedLimit        int64          // bytes per second, 0 for no own limit
+    Weight            int            // share of the global bandwidth, 0 uses storage.DefaultWeight
+    QueueID           int64          // 0 uses the main queue
+    Priority          Priority       // where the download joins its queue
+    DependsOn         []int64        // downloads that must complete first
+    PackageID         int64          // package the download belongs to, 0 for none
 }
 
 func DefaultConfig() *DownloadConfig {
@@ -36,5 +72,8 @@ func DefaultConfig() *DownloadConfig {
         RetryAttempts:          3,
         UserAgent:              "IDM-Go/1.0",
         Timeout:                30 * time.Second,
+        ConflictPolicy:         ConflictRename,
+        DiscoverChecksums:      true,
+        AutoResume:             true,
     }
 }
\ No newline at end of file
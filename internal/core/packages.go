@@ -0,0 +1,267 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+type Package = storage.Package
+
+// PackageInfo sums up the downloads of a package
+type PackageInfo struct {
+    Package
+    Downloads  []*Download
+    Size       int64 // 0 while the size of a download is unknown
+    Downloaded int64
+    Speed      int64
+    Progress   float64
+    ETA        time.Duration // 0 when it cannot be told
+    Status     DownloadStatus
+    Completed  int
+    Failed     int
+}
+
+// CreatePackage stores a new, empty package
+func (dm *DownloadManager) CreatePackage(name string) (*Package, error) {
+    name = strings.TrimSpace(name)
+    if name == "" {
+        return nil, fmt.Errorf("package name is required")
+    }
+
+    pkg := &Package{Name: name, CreatedAt: time.Now()}
+    id, err := dm.store.SavePackage(pkg)
+    if err != nil {
+        return nil, err
+    }
+    pkg.ID = id
+
+    return pkg, nil
+}
+
+// AddPackage creates a package and adds a download for every URL to it. A
+// URL that cannot be added does not keep the others from being added; the
+// errors are returned together.
+func (dm *DownloadManager) AddPackage(name string, urls []string, path string, options DownloadOptions) (*Package, []*Download, error) {
+    pkg, err := dm.CreatePackage(name)
+    if err != nil {
+        return nil, nil, err
+    }
+    options.PackageID = pkg.ID
+
+    var downloads []*Download
+    var errs []error
+    for _, url := range urls {
+        download, err := dm.AddDownloadWithOptions(url, path, options)
+        if err != nil {
+            errs = append(errs, fmt.Errorf("%s: %w", url, err))
+            continue
+        }
+        downloads = append(downloads, download)
+    }
+
+    return pkg, downloads, errors.Join(errs...)
+}
+
+// GetPackages returns all packages with their downloads, newest first
+func (dm *DownloadManager) GetPackages() ([]*PackageInfo, error) {
+    packages, err := dm.store.GetPackages()
+    if err != nil {
+        return nil, err
+    }
+    downloads, err := dm.store.GetAllDownloads()
+    if err != nil {
+        return nil, err
+    }
+
+    members := make(map[int64][]*Download)
+    for i := len(downloads) - 1; i >= 0; i-- {
+        if id := downloads[i].PackageID; id != 0 {
+            members[id] = append(members[id], downloads[i])
+        }
+    }
+
+    infos := make([]*PackageInfo, len(packages))
+    for i, pkg := range packages {
+        infos[i] = SummarizePackage(pkg, members[pkg.ID])
+    }
+
+    return infos, nil
+}
+
+// GetPackage returns a package with its downloads
+func (dm *DownloadManager) GetPackage(id int64) (*PackageInfo, error) {
+    pkg, err := dm.store.GetPackage(id)
+    if err != nil {
+        return nil, fmt.Errorf("package %d not found", id)
+    }
+    downloads, err := dm.store.GetPackageDownloads(id)
+    if err != nil {
+        return nil, err
+    }
+
+    return SummarizePackage(pkg, downloads), nil
+}
+
+// DeletePackage ungroups a package, its downloads stay
+func (dm *DownloadManager) DeletePackage(id int64) error {
+    return dm.store.DeletePackage(id)
+}
+
+// StartPackage queues every download of the package that is not done yet.
+// They start as their queues allow, like any other queued download.
+func (dm *DownloadManager) StartPackage(id int64) error {
+    info, err := dm.GetPackage(id)
+    if err != nil {
+        return err
+    }
+
+    for _, download := range info.Downloads {
+        switch download.Status {
+        case StatusCompleted, StatusDownloading, StatusPending, StatusBlocked:
+            continue
+        }
+        if dm.isRunning(download.ID) {
+            continue
+        }
+
+        download.Status = StatusPending
+        download.Error = ""
+        if err := dm.store.UpdateDownload(download); err != nil {
+            return err
+        }
+        dm.enqueue(download)
+        dm.publishStatus(download)
+        dm.unblockDependents(download.ID)
+    }
+
+    return nil
+}
+
+// PausePackage pauses the running downloads of the package and takes the
+// queued ones out of their queues
+func (dm *DownloadManager) PausePackage(id int64) error {
+    info, err := dm.GetPackage(id)
+    if err != nil {
+        return err
+    }
+
+    for _, download := range info.Downloads {
+        if dm.isRunning(download.ID) {
+            dm.PauseDownload(download.ID)
+            continue
+        }
+        if download.Status != StatusPending {
+            continue
+        }
+
+        dm.removeFromQueues(download.ID)
+        download.Status = StatusPaused
+        if err := dm.store.UpdateDownload(download); err != nil {
+            return err
+        }
+        dm.publishStatus(download)
+    }
+
+    return nil
+}
+
+// CancelPackage cancels every download of the package that is not done yet
+func (dm *DownloadManager) CancelPackage(id int64) error {
+    info, err := dm.GetPackage(id)
+    if err != nil {
+        return err
+    }
+
+    for _, download := range info.Downloads {
+        if download.Status == StatusCompleted || download.Status == StatusCancelled {
+            continue
+        }
+        if err := dm.CancelDownload(download.ID); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// checkPackageComplete publishes EventPackageCompleted when the download that
+// just completed was the last one of its package
+func (dm *DownloadManager) checkPackageComplete(download *Download) {
+    if download.PackageID == 0 {
+        return
+    }
+
+    info, err := dm.GetPackage(download.PackageID)
+    if err != nil || info.Status != StatusCompleted {
+        return
+    }
+    dm.publishPackageCompleted(info)
+}
+
+func (dm *DownloadManager) isRunning(id int64) bool {
+    dm.mutex.RLock()
+    defer dm.mutex.RUnlock()
+
+    _, running := dm.downloads[id]
+    return running
+}
+
+// SummarizePackage adds up the downloads of a package
+func SummarizePackage(pkg *Package, downloads []*Download) *PackageInfo {
+    info := &PackageInfo{Package: *pkg, Downloads: downloads}
+
+    sizeKnown := true
+    var active, queued, paused bool
+    for _, download := range downloads {
+        if download.Size > 0 {
+            info.Size += download.Size
+        } else {
+            sizeKnown = false
+        }
+        info.Downloaded += download.Downloaded
+
+        switch {
+        case download.Status == StatusCompleted:
+            info.Completed++
+        case download.Status == StatusDownloading:
+            active = true
+            info.Speed += download.Speed
+        case download.Status == StatusPending:
+            queued = true
+        case download.Status == StatusPaused:
+            paused = true
+        case isFailure(download.Status):
+            info.Failed++
+        }
+    }
+    if !sizeKnown {
+        info.Size = 0
+    }
+
+    if info.Size > 0 {
+        info.Progress = float64(info.Downloaded) / float64(info.Size) * 100
+        info.ETA = estimateETA(info.Size, info.Downloaded, info.Speed)
+    }
+
+    // The package is as far along as its least finished download
+    switch {
+    case len(downloads) == 0:
+        info.Status = StatusPending
+    case active:
+        info.Status = StatusDownloading
+    case queued:
+        info.Status = StatusPending
+    case paused:
+        info.Status = StatusPaused
+    case info.Failed > 0:
+        info.Status = StatusFailed
+    default:
+        info.Status = StatusCompleted
+    }
+
+    return info
+}
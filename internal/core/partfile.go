@@ -0,0 +1,191 @@
+package core
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+
+    "idm-go/internal/storage"
+)
+
+// While a download runs, its data lives in "<name>.part" next to a small
+// "<name>.part.json" sidecar describing the chunk layout. Only a finished
+// file is renamed to its real name, so a crash never leaves a truncated file
+// that looks complete.
+
+const (
+    partSuffix    = ".part"
+    sidecarSuffix = ".part.json"
+)
+
+// sidecar is the resume metadata stored next to a .part file. It allows a
+// download to resume even when the database lost its chunk rows.
+type sidecar struct {
+    URL    string           `json:"url"`
+    Size   int64            `json:"size"`
+    Chunks []*storage.Chunk `json:"chunks"`
+}
+
+// targetPath returns where a download is written, refusing names that would
+// leave the download directory
+func targetPath(download *storage.Download) (string, error) {
+    if download.Filename == "" || sanitizeFilename(download.Filename) != download.Filename {
+        return "", fmt.Errorf("invalid file name %q", download.Filename)
+    }
+    return filepath.Join(download.Path, download.Filename), nil
+}
+
+// openPartialFile opens the .part file for writing without truncating it, so
+// bytes from an earlier run stay in place. existed reports whether there was
+// anything on disk to resume from. Under ConflictResume the .part file starts
+// as a copy of an existing file with the final name; the file itself stays
+// untouched until the download completes, so a cancel cannot lose it.
+func openPartialFile(download *storage.Download) (file *os.File, existed bool, err error) {
+    fullPath, err := targetPath(download)
+    if err != nil {
+        return nil, false, err
+    }
+    partPath := fullPath + partSuffix
+
+    if _, statErr := os.Stat(partPath); statErr == nil {
+        existed = true
+    } else if download.Conflict == ConflictResume && download.Downloaded == 0 {
+        if err := copyFile(fullPath, partPath); err == nil {
+            existed = true
+        }
+    }
+
+    file, err = os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+    return file, existed, err
+}
+
+// finishPartialFile moves a completed .part file to its final name and drops
+// the sidecar. A file under that name is only replaced under
+// ConflictOverwrite, or ConflictResume, which resumed from it; otherwise a
+// free name is picked.
+func (dm *DownloadManager) finishPartialFile(download *storage.Download) error {
+    dm.pathMutex.Lock()
+    defer dm.pathMutex.Unlock()
+
+    fullPath, err := targetPath(download)
+    if err != nil {
+        return err
+    }
+    partPath := fullPath + partSuffix
+    sidecarPath := fullPath + sidecarSuffix
+
+    replace := download.Conflict == ConflictOverwrite || download.Conflict == ConflictResume
+    if _, err := os.Lstat(fullPath); err == nil && !replace {
+        reserved, err := dm.reservedNames(download.Path)
+        if err != nil {
+            return err
+        }
+        name, err := uniqueFilename(download.Path, download.Filename, reserved)
+        if err != nil {
+            return err
+        }
+        download.Filename = name
+        fullPath = filepath.Join(download.Path, name)
+    }
+
+    if err := os.Rename(partPath, fullPath); err != nil {
+        return err
+    }
+    os.Remove(sidecarPath)
+
+    return nil
+}
+
+// copyFile copies src to dst through a temporary file, so dst either has
+// all of src or does not exist
+func copyFile(src, dst string) error {
+    in, err := os.Open(src)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+
+    tmp := dst + ".tmp"
+    out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp)
+
+    if _, err := io.Copy(out, in); err != nil {
+        out.Close()
+        return err
+    }
+    if err := out.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp, dst)
+}
+
+// removePartialFiles deletes the .part file and its sidecar
+func removePartialFiles(download *storage.Download) {
+    fullPath, err := targetPath(download)
+    if err != nil {
+        return
+    }
+
+    os.Remove(fullPath + partSuffix)
+    os.Remove(fullPath + sidecarSuffix)
+}
+
+func removeSidecar(download *storage.Download) {
+    if fullPath, err := targetPath(download); err == nil {
+        os.Remove(fullPath + sidecarSuffix)
+    }
+}
+
+// writeSidecar stores the chunk layout next to the .part file. It writes to a
+// temporary file first so a crash cannot leave half a sidecar behind.
+func writeSidecar(download *storage.Download, chunks []*storage.Chunk) error {
+    fullPath, err := targetPath(download)
+    if err != nil {
+        return err
+    }
+
+    data, err := json.Marshal(&sidecar{
+        URL:    download.URL,
+        Size:   download.Size,
+        Chunks: chunks,
+    })
+    if err != nil {
+        return err
+    }
+
+    path := fullPath + sidecarSuffix
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// readSidecar returns the chunk layout stored next to the .part file, or nil
+// if there is none or it belongs to another URL or size
+func readSidecar(download *storage.Download) []*storage.Chunk {
+    fullPath, err := targetPath(download)
+    if err != nil {
+        return nil
+    }
+
+    data, err := os.ReadFile(fullPath + sidecarSuffix)
+    if err != nil {
+        return nil
+    }
+
+    var meta sidecar
+    if err := json.Unmarshal(data, &meta); err != nil {
+        return nil
+    }
+    if meta.URL != download.URL || meta.Size != download.Size {
+        return nil
+    }
+
+    return meta.Chunks
+}
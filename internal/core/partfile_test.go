@@ -0,0 +1,102 @@
+package core
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+// TestCancelAfterResume resumes a file that is already there under
+// ConflictResume and cancels the download halfway. The file must be left
+// as it was.
+func TestCancelAfterResume(t *testing.T) {
+    content := bytes.Repeat([]byte("0123456789"), 100)
+    existing := content[:len(content)/2]
+
+    requested := make(chan struct{}, 1)
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Accept-Ranges", "bytes")
+        if r.Method == "HEAD" {
+            w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+            return
+        }
+
+        var start int
+        fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+        w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+        w.WriteHeader(http.StatusPartialContent)
+        w.Write(content[start : start+1])
+        w.(http.Flusher).Flush()
+
+        // Stalls until the download is cancelled
+        select {
+        case requested <- struct{}{}:
+        default:
+        }
+        <-r.Context().Done()
+    }))
+    defer server.Close()
+
+    dir := t.TempDir()
+    target := filepath.Join(dir, "file.bin")
+    if err := os.WriteFile(target, existing, 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    store := storage.NewMemoryRepository()
+    dm, err := NewDownloadManager(store)
+    if err != nil {
+        t.Fatal(err)
+    }
+    id, err := store.SaveDownload(&storage.Download{
+        URL:       server.URL + "/file.bin",
+        Filename:  "file.bin",
+        Path:      dir,
+        Size:      int64(len(content)),
+        Status:    StatusPending,
+        CreatedAt: time.Now(),
+        Chunks:    1,
+        Conflict:  ConflictResume,
+        QueueID:   storage.MainQueueID,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if err := dm.StartDownload(id); err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case <-requested:
+    case <-time.After(5 * time.Second):
+        t.Fatal("the download did not resume")
+    }
+    if err := dm.CancelDownload(id); err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := dm.Shutdown(ctx); err != nil {
+        t.Fatal(err)
+    }
+
+    data, err := os.ReadFile(target)
+    if err != nil {
+        t.Fatalf("the resumed file is gone after a cancel: %v", err)
+    }
+    if !bytes.Equal(data, existing) {
+        t.Errorf("the resumed file was changed to %d bytes", len(data))
+    }
+    if _, err := os.Stat(target + partSuffix); err == nil {
+        t.Error("the .part file was left behind")
+    }
+}
@@ -5,36 +5,183 @@ import (
     "idm-go/internal/storage"
 )
 
+type QueueInfo = storage.QueueInfo
+
 type Queue struct {
+    info  storage.QueueInfo
     items []*storage.Download
     mutex sync.RWMutex
 }
 
-func NewQueue() *Queue {
+func NewQueue(info *storage.QueueInfo) *Queue {
     return &Queue{
+        info:  *info,
         items: make([]*storage.Download, 0),
     }
 }
 
+func (q *Queue) ID() int64 {
+    q.mutex.RLock()
+    defer q.mutex.RUnlock()
+
+    return q.info.ID
+}
+
+// Info returns a copy of the queue settings
+func (q *Queue) Info() *QueueInfo {
+    q.mutex.RLock()
+    defer q.mutex.RUnlock()
+
+    info := q.info
+    return &info
+}
+
+func (q *Queue) setInfo(info *QueueInfo) {
+    q.mutex.Lock()
+    defer q.mutex.Unlock()
+
+    q.info = *info
+}
+
+// Start lets processQueue start the queued downloads
+func (q *Queue) Start() {
+    q.mutex.Lock()
+    defer q.mutex.Unlock()
+
+    q.info.Running = true
+}
+
+// Stop keeps processQueue from starting more downloads, the queued ones wait
+func (q *Queue) Stop() {
+    q.mutex.Lock()
+    defer q.mutex.Unlock()
+
+    q.info.Running = false
+}
+
+func (q *Queue) Running() bool {
+    q.mutex.RLock()
+    defer q.mutex.RUnlock()
+
+    return q.info.Running
+}
+
+// Add puts a download behind the waiting downloads of the same or higher
+// priority, ahead of those with a lower one
 func (q *Queue) Add(download *storage.Download) {
     q.mutex.Lock()
     defer q.mutex.Unlock()
     
-    q.items = append(q.items, download)
+    position := len(q.items)
+    for i, item := range q.items {
+        if item.Priority < download.Priority {
+            position = i
+            break
+        }
+    }
+
+    q.items = append(q.items, nil)
+    copy(q.items[position+1:], q.items[position:])
+    q.items[position] = download
 }
 
-func (q *Queue) Next() *storage.Download {
+// restore appends downloads in the given order, which already reflects
+// their priorities
+func (q *Queue) restore(downloads []*storage.Download) {
     q.mutex.Lock()
     defer q.mutex.Unlock()
-    
+
+    q.items = append(q.items, downloads...)
+}
+
+// MoveUp swaps a download with the one ahead of it
+func (q *Queue) MoveUp(id int64) bool {
+    return q.move(id, func(i int) int { return i - 1 })
+}
+
+// MoveDown swaps a download with the one behind it
+func (q *Queue) MoveDown(id int64) bool {
+    return q.move(id, func(i int) int { return i + 1 })
+}
+
+// MoveToTop makes a download the next one to start
+func (q *Queue) MoveToTop(id int64) bool {
+    return q.move(id, func(int) int { return 0 })
+}
+
+// MoveToBottom makes a download the last one to start
+func (q *Queue) MoveToBottom(id int64) bool {
+    return q.move(id, func(int) int { return len(q.items) - 1 })
+}
+
+// move moves a download to the position target returns for its current
+// one. It reports whether the download is queued and actually moved.
+func (q *Queue) move(id int64, target func(int) int) bool {
+    q.mutex.Lock()
+    defer q.mutex.Unlock()
+
+    from := q.indexOf(id)
+    if from < 0 {
+        return false
+    }
+    to := target(from)
+    if to < 0 || to >= len(q.items) || to == from {
+        return false
+    }
+
+    download := q.items[from]
+    if to < from {
+        copy(q.items[to+1:from+1], q.items[to:from])
+    } else {
+        copy(q.items[from:to], q.items[from+1:to+1])
+    }
+    q.items[to] = download
+    return true
+}
+
+// Contains reports whether a download waits in the queue
+func (q *Queue) Contains(id int64) bool {
+    q.mutex.RLock()
+    defer q.mutex.RUnlock()
+
+    return q.indexOf(id) >= 0
+}
+
+// IDs returns the IDs of the waiting downloads in order
+func (q *Queue) IDs() []int64 {
+    q.mutex.RLock()
+    defer q.mutex.RUnlock()
+
+    ids := make([]int64, len(q.items))
+    for i, download := range q.items {
+        ids[i] = download.ID
+    }
+    return ids
+}
+
+// indexOf returns the position of a download, -1 if it is not queued. Must
+// hold q.mutex.
+func (q *Queue) indexOf(id int64) int {
     for i, download := range q.items {
-        if download.Status == storage.StatusPending {
-            // Remove from queue
+        if download.ID == id {
+            return i
+        }
+    }
+    return -1
+}
+
+// NextReady removes and returns the first pending download ready accepts
+func (q *Queue) NextReady(ready func(*storage.Download) bool) *storage.Download {
+    q.mutex.Lock()
+    defer q.mutex.Unlock()
+
+    for i, download := range q.items {
+        if download.Status == storage.StatusPending && ready(download) {
             q.items = append(q.items[:i], q.items[i+1:]...)
             return download
         }
     }
-    
+
     return nil
 }
 
@@ -0,0 +1,399 @@
+package core
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "idm-go/internal/storage"
+)
+
+// loadQueues builds the queues from the database. The main queue always
+// exists, even when the database has no queues.
+func (dm *DownloadManager) loadQueues() error {
+    infos, err := dm.store.GetQueues()
+    if err != nil {
+        return err
+    }
+    if len(infos) == 0 {
+        infos = []*QueueInfo{{ID: storage.MainQueueID, Name: "Main", MaxConcurrent: 3, Running: true}}
+    }
+
+    dm.queuesMutex.Lock()
+    defer dm.queuesMutex.Unlock()
+
+    for _, info := range infos {
+        dm.queues[info.ID] = NewQueue(info)
+        dm.limiter.SetGroupLimit(info.ID, info.MaxSpeed)
+    }
+
+    return nil
+}
+
+// restoreQueues puts the waiting downloads back into their queues after a
+// restart. Downloads that were running when the program stopped are queued
+// again ahead of the others, or paused when AutoResume is off.
+func (dm *DownloadManager) restoreQueues() error {
+    downloads, err := dm.store.GetAllDownloads()
+    if err != nil {
+        return err
+    }
+
+    var interrupted, waiting []*Download
+    for _, download := range downloads {
+        switch download.Status {
+        case StatusDownloading:
+            download.Speed = 0
+            if dm.Config().AutoResume {
+                download.Status = StatusPending
+                interrupted = append(interrupted, download)
+            } else {
+                download.Status = StatusPaused
+            }
+            if err := dm.store.UpdateDownload(download); err != nil {
+                return err
+            }
+        case StatusPending:
+            waiting = append(waiting, download)
+        }
+    }
+
+    sort.SliceStable(waiting, func(i, j int) bool {
+        if waiting[i].QueuePosition != waiting[j].QueuePosition {
+            return waiting[i].QueuePosition < waiting[j].QueuePosition
+        }
+        return waiting[i].CreatedAt.Before(waiting[j].CreatedAt)
+    })
+
+    byQueue := make(map[*Queue][]*Download)
+    for _, download := range append(interrupted, waiting...) {
+        queue := dm.queueFor(download)
+        byQueue[queue] = append(byQueue[queue], download)
+    }
+    for queue, downloads := range byQueue {
+        queue.restore(downloads)
+        if err := dm.saveQueueOrder(queue); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// GetQueues returns the settings of all queues, the main queue first
+func (dm *DownloadManager) GetQueues() []*QueueInfo {
+    var infos []*QueueInfo
+    for _, queue := range dm.queueList() {
+        infos = append(infos, queue.Info())
+    }
+    return infos
+}
+
+// CreateQueue adds a named queue
+func (dm *DownloadManager) CreateQueue(info *QueueInfo) error {
+    if err := validateQueue(info); err != nil {
+        return err
+    }
+
+    info.ID = 0
+    id, err := dm.store.SaveQueue(info)
+    if err != nil {
+        return err
+    }
+    info.ID = id
+
+    dm.queuesMutex.Lock()
+    dm.queues[id] = NewQueue(info)
+    dm.queuesMutex.Unlock()
+
+    dm.limiter.SetGroupLimit(id, info.MaxSpeed)
+    return nil
+}
+
+// UpdateQueue changes the name and limits of a queue. Its running state is
+// left alone, StartQueue and StopQueue change that.
+func (dm *DownloadManager) UpdateQueue(info *QueueInfo) error {
+    queue, err := dm.queueByID(info.ID)
+    if err != nil {
+        return err
+    }
+    if err := validateQueue(info); err != nil {
+        return err
+    }
+
+    info.Running = queue.Running()
+    if _, err := dm.store.SaveQueue(info); err != nil {
+        return err
+    }
+    queue.setInfo(info)
+
+    dm.limiter.SetGroupLimit(info.ID, info.MaxSpeed)
+    return nil
+}
+
+// DeleteQueue removes a queue and its schedules. Its downloads, waiting or
+// running, move to the main queue.
+func (dm *DownloadManager) DeleteQueue(queueID int64) error {
+    queue, err := dm.queueByID(queueID)
+    if err != nil {
+        return err
+    }
+    if queueID == storage.MainQueueID {
+        return fmt.Errorf("the main queue cannot be deleted")
+    }
+
+    if err := dm.store.DeleteQueue(queueID); err != nil {
+        return err
+    }
+
+    dm.queuesMutex.Lock()
+    delete(dm.queues, queueID)
+    mainQueue := dm.queues[storage.MainQueueID]
+    dm.queuesMutex.Unlock()
+
+    for _, download := range queue.GetAll() {
+        queue.Remove(download.ID)
+        download.QueueID = storage.MainQueueID
+        mainQueue.Add(download)
+    }
+    dm.saveQueueOrder(mainQueue)
+    for _, job := range dm.queueJobs(queueID) {
+        job.setQueue(storage.MainQueueID)
+    }
+
+    dm.limiter.SetGroupLimit(queueID, 0)
+    dm.queueSchedulesUpdated()
+    return nil
+}
+
+// MoveToQueue moves a download to another queue. A running download keeps
+// running and shares the bandwidth of its new queue.
+func (dm *DownloadManager) MoveToQueue(downloadID, queueID int64) error {
+    target, err := dm.queueByID(queueID)
+    if err != nil {
+        return err
+    }
+
+    if err := dm.store.SetDownloadQueue(downloadID, queueID); err != nil {
+        return err
+    }
+
+    dm.mutex.RLock()
+    job, running := dm.downloads[downloadID]
+    dm.mutex.RUnlock()
+    if running {
+        job.setQueue(queueID)
+    }
+
+    for _, queue := range dm.queueList() {
+        for _, download := range queue.GetAll() {
+            if download.ID == downloadID && queue != target {
+                queue.Remove(downloadID)
+                download.QueueID = queueID
+                target.Add(download)
+                dm.saveQueueOrder(target)
+            }
+        }
+    }
+
+    if download, err := dm.store.GetDownload(downloadID); err == nil {
+        dm.publishStatus(download)
+    }
+    return nil
+}
+
+// MoveUp moves a waiting download one place ahead in its queue
+func (dm *DownloadManager) MoveUp(downloadID int64) error {
+    return dm.reorder(downloadID, (*Queue).MoveUp)
+}
+
+// MoveDown moves a waiting download one place back in its queue
+func (dm *DownloadManager) MoveDown(downloadID int64) error {
+    return dm.reorder(downloadID, (*Queue).MoveDown)
+}
+
+// MoveToTop makes a waiting download the next one its queue starts
+func (dm *DownloadManager) MoveToTop(downloadID int64) error {
+    return dm.reorder(downloadID, (*Queue).MoveToTop)
+}
+
+// MoveToBottom makes a waiting download the last one its queue starts
+func (dm *DownloadManager) MoveToBottom(downloadID int64) error {
+    return dm.reorder(downloadID, (*Queue).MoveToBottom)
+}
+
+// reorder applies a move to the queue holding the download and stores the
+// new order. Moving past either end of the queue is not an error.
+func (dm *DownloadManager) reorder(downloadID int64, move func(*Queue, int64) bool) error {
+    for _, queue := range dm.queueList() {
+        if !queue.Contains(downloadID) {
+            continue
+        }
+        if move(queue, downloadID) {
+            return dm.saveQueueOrder(queue)
+        }
+        return nil
+    }
+    return fmt.Errorf("download is not waiting in a queue")
+}
+
+// SetPriority changes the priority of a download. A waiting download takes
+// the place in its queue the new priority gives it.
+func (dm *DownloadManager) SetPriority(downloadID int64, priority Priority) error {
+    if priority < PriorityLow || priority > PriorityHigh {
+        return fmt.Errorf("unknown priority")
+    }
+
+    if err := dm.store.SetDownloadPriority(downloadID, priority); err != nil {
+        return err
+    }
+
+    dm.mutex.RLock()
+    job, running := dm.downloads[downloadID]
+    dm.mutex.RUnlock()
+    if running {
+        job.mutex.Lock()
+        job.download.Priority = priority
+        job.mutex.Unlock()
+    }
+
+    for _, queue := range dm.queueList() {
+        for _, download := range queue.GetAll() {
+            if download.ID == downloadID {
+                queue.Remove(downloadID)
+                download.Priority = priority
+                queue.Add(download)
+                dm.saveQueueOrder(queue)
+            }
+        }
+    }
+
+    return nil
+}
+
+// QueueOrder returns the IDs of the downloads waiting in a queue, next first
+func (dm *DownloadManager) QueueOrder(queueID int64) ([]int64, error) {
+    queue, err := dm.queueByID(queueID)
+    if err != nil {
+        return nil, err
+    }
+    return queue.IDs(), nil
+}
+
+// enqueue adds a download to its queue and stores the new order
+func (dm *DownloadManager) enqueue(download *Download) {
+    queue := dm.queueFor(download)
+    queue.Add(download)
+    dm.saveQueueOrder(queue)
+}
+
+func (dm *DownloadManager) saveQueueOrder(queue *Queue) error {
+    return dm.store.SaveQueueOrder(queue.IDs())
+}
+
+// StartQueue lets the downloads of a queue start
+func (dm *DownloadManager) StartQueue(queueID int64) error {
+    return dm.setQueueRunning(queueID, true)
+}
+
+// StopQueue keeps a queue from starting more downloads. Its running
+// downloads are paused and wait in the queue until it is started again.
+func (dm *DownloadManager) StopQueue(queueID int64) error {
+    if err := dm.setQueueRunning(queueID, false); err != nil {
+        return err
+    }
+
+    for _, job := range dm.queueJobs(queueID) {
+        job.stopForQueue()
+    }
+    return nil
+}
+
+func (dm *DownloadManager) setQueueRunning(queueID int64, running bool) error {
+    queue, err := dm.queueByID(queueID)
+    if err != nil {
+        return err
+    }
+
+    if running {
+        queue.Start()
+    } else {
+        queue.Stop()
+    }
+
+    _, err = dm.store.SaveQueue(queue.Info())
+    return err
+}
+
+// queueByID returns the queue with the given ID
+func (dm *DownloadManager) queueByID(queueID int64) (*Queue, error) {
+    dm.queuesMutex.RLock()
+    defer dm.queuesMutex.RUnlock()
+
+    queue, ok := dm.queues[queueID]
+    if !ok {
+        return nil, fmt.Errorf("queue not found")
+    }
+    return queue, nil
+}
+
+// queueFor returns the queue of a download, the main queue if its own is gone
+func (dm *DownloadManager) queueFor(download *Download) *Queue {
+    dm.queuesMutex.RLock()
+    defer dm.queuesMutex.RUnlock()
+
+    if queue, ok := dm.queues[download.QueueID]; ok {
+        return queue
+    }
+    download.QueueID = storage.MainQueueID
+    return dm.queues[storage.MainQueueID]
+}
+
+// queueList returns the queues ordered by ID
+func (dm *DownloadManager) queueList() []*Queue {
+    dm.queuesMutex.RLock()
+    queues := make([]*Queue, 0, len(dm.queues))
+    for _, queue := range dm.queues {
+        queues = append(queues, queue)
+    }
+    dm.queuesMutex.RUnlock()
+
+    sort.Slice(queues, func(i, j int) bool {
+        return queues[i].ID() < queues[j].ID()
+    })
+    return queues
+}
+
+// removeFromQueues takes a download out of whichever queue holds it
+func (dm *DownloadManager) removeFromQueues(downloadID int64) {
+    for _, queue := range dm.queueList() {
+        queue.Remove(downloadID)
+    }
+}
+
+// queueJobs returns the running jobs of a queue
+func (dm *DownloadManager) queueJobs(queueID int64) []*DownloadJob {
+    dm.mutex.RLock()
+    defer dm.mutex.RUnlock()
+
+    var jobs []*DownloadJob
+    for _, job := range dm.downloads {
+        if job.queueID() == queueID {
+            jobs = append(jobs, job)
+        }
+    }
+    return jobs
+}
+
+func validateQueue(info *QueueInfo) error {
+    info.Name = strings.TrimSpace(info.Name)
+    if info.Name == "" {
+        return fmt.Errorf("queue name is required")
+    }
+    if info.MaxConcurrent < 1 {
+        return fmt.Errorf("queue must allow at least one download at a time")
+    }
+    if info.MaxSpeed < 0 {
+        return fmt.Errorf("queue speed limit must not be negative")
+    }
+    return nil
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+    "testing"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+// TestRestoreQueuesAutoResume saves AutoResume like the settings window
+// does, and checks that the next start treats an interrupted download by
+// the saved value
+func TestRestoreQueuesAutoResume(t *testing.T) {
+    tests := []struct {
+        autoResume bool
+        status     DownloadStatus
+        queued     bool
+    }{
+        {autoResume: true, status: StatusPending, queued: true},
+        {autoResume: false, status: StatusPaused, queued: false},
+    }
+
+    for _, test := range tests {
+        store := storage.NewMemoryRepository()
+
+        dm, err := NewDownloadManager(store)
+        if err != nil {
+            t.Fatal(err)
+        }
+        config := dm.Config()
+        config.AutoResume = test.autoResume
+        if err := dm.UpdateConfig(config); err != nil {
+            t.Fatal(err)
+        }
+
+        id, err := store.SaveDownload(&storage.Download{
+            URL:       "http://example.com/file.bin",
+            Filename:  "file.bin",
+            Path:      t.TempDir(),
+            Status:    StatusDownloading,
+            CreatedAt: time.Now(),
+            QueueID:   storage.MainQueueID,
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        // The next start, with the same store
+        dm, err = NewDownloadManager(store)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if dm.Config().AutoResume != test.autoResume {
+            t.Errorf("AutoResume %v was loaded as %v", test.autoResume, dm.Config().AutoResume)
+        }
+
+        download, err := store.GetDownload(id)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if download.Status != test.status {
+            t.Errorf("AutoResume %v: status %v, want %v", test.autoResume, download.Status, test.status)
+        }
+        queue, err := dm.queueByID(storage.MainQueueID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if queue.Contains(id) != test.queued {
+            t.Errorf("AutoResume %v: queued %v, want %v", test.autoResume, queue.Contains(id), test.queued)
+        }
+    }
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "math/rand"
+    "net"
+    "net/http"
+    "strconv"
+    "sync/atomic"
+    "syscall"
+    "time"
+)
+
+const (
+    retryBaseDelay     = 500 * time.Millisecond
+    retryMaxDelay      = 30 * time.Second
+    retryMaxRetryAfter = 5 * time.Minute
+)
+
+// errStalled is returned when a connection delivered no data for longer than
+// the configured timeout
+var errStalled = errors.New("connection stalled")
+
+// errShortBody is returned when the server closed the connection before the
+// requested range was complete
+var errShortBody = errors.New("connection closed before the range was complete")
+
+// HTTPStatusError is returned for responses that did not carry the expected
+// status code
+type HTTPStatusError struct {
+    StatusCode int
+    Status     string
+    RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+    return fmt.Sprintf("server responded with %s", e.Status)
+}
+
+func newHTTPStatusError(resp *http.Response) *HTTPStatusError {
+    return &HTTPStatusError{
+        StatusCode: resp.StatusCode,
+        Status:     resp.Status,
+        RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+    }
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+    if value == "" {
+        return 0
+    }
+
+    if seconds, err := strconv.Atoi(value); err == nil {
+        if seconds < 0 {
+            return 0
+        }
+        return time.Duration(seconds) * time.Second
+    }
+
+    if date, err := http.ParseTime(value); err == nil {
+        if d := date.Sub(now); d > 0 {
+            return d
+        }
+    }
+
+    return 0
+}
+
+// isRetryable tells transient network and server failures apart from
+// permanent ones such as 404 or 403, a host that does not exist or a
+// refused connection, which must fail the download at once
+func isRetryable(err error) bool {
+    if err == nil {
+        return false
+    }
+
+    var statusErr *HTTPStatusError
+    if errors.As(err, &statusErr) {
+        return statusErr.StatusCode == http.StatusTooManyRequests ||
+            statusErr.StatusCode == http.StatusRequestTimeout ||
+            statusErr.StatusCode >= 500
+    }
+
+    var dnsErr *net.DNSError
+    if errors.As(err, &dnsErr) {
+        return !dnsErr.IsNotFound && (dnsErr.IsTimeout || dnsErr.IsTemporary)
+    }
+    if errors.Is(err, syscall.ECONNREFUSED) {
+        return false
+    }
+
+    // A connection that broke off or went quiet may work the next time
+    if errors.Is(err, errStalled) || errors.Is(err, errShortBody) ||
+        errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+        errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) ||
+        errors.Is(err, syscall.EPIPE) || errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+
+    var netErr net.Error
+    return errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary())
+}
+
+// retryDelay returns how long to wait before the given retry attempt
+// (starting at 1). A Retry-After sent by the server wins over the jittered
+// exponential backoff.
+func retryDelay(attempt int, err error) time.Duration {
+    var statusErr *HTTPStatusError
+    if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+        if statusErr.RetryAfter > retryMaxRetryAfter {
+            return retryMaxRetryAfter
+        }
+        return statusErr.RetryAfter
+    }
+
+    delay := retryMaxDelay
+    if attempt < 16 {
+        if d := retryBaseDelay << uint(attempt-1); d < retryMaxDelay {
+            delay = d
+        }
+    }
+
+    // Equal jitter keeps at least half of the delay so retries of several
+    // chunks do not hammer the server at the same instant
+    half := delay / 2
+    return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-timer.C:
+        return nil
+    }
+}
+
+// stallWatchdog cancels a request that delivered no data for longer than
+// timeout. An overall http.Client timeout would also cut off healthy but
+// long transfers.
+type stallWatchdog struct {
+    timer   *time.Timer
+    timeout time.Duration
+    fired   int32
+}
+
+func newStallWatchdog(timeout time.Duration, cancel context.CancelFunc) *stallWatchdog {
+    w := &stallWatchdog{timeout: timeout}
+    w.timer = time.AfterFunc(timeout, func() {
+        atomic.StoreInt32(&w.fired, 1)
+        cancel()
+    })
+    return w
+}
+
+func (w *stallWatchdog) Reset() {
+    w.timer.Reset(w.timeout)
+}
+
+func (w *stallWatchdog) Stop() {
+    w.timer.Stop()
+}
+
+// wrap reports errStalled for errors caused by the watchdog cancelling the request
+func (w *stallWatchdog) wrap(err error) error {
+    if err != nil && atomic.LoadInt32(&w.fired) == 1 {
+        return errStalled
+    }
+    return err
+}
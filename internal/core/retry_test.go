@@ -0,0 +1,48 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "net/url"
+    "os"
+    "syscall"
+    "testing"
+)
+
+func TestIsRetryable(t *testing.T) {
+    dial := func(err error) error {
+        return &url.Error{Op: "Get", URL: "http://example.com/file.bin", Err: &net.OpError{
+            Op:  "dial",
+            Net: "tcp",
+            Err: os.NewSyscallError("connect", err),
+        }}
+    }
+
+    tests := []struct {
+        name string
+        err  error
+        want bool
+    }{
+        {"no error", nil, false},
+        {"service unavailable", &HTTPStatusError{StatusCode: 503}, true},
+        {"too many requests", &HTTPStatusError{StatusCode: 429}, true},
+        {"not found", &HTTPStatusError{StatusCode: 404}, false},
+        {"forbidden", &HTTPStatusError{StatusCode: 403}, false},
+        {"no such host", &url.Error{Op: "Get", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "nowhere.invalid", IsNotFound: true}}}, false},
+        {"dns timeout", &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}, true},
+        {"dns server failure", &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}, true},
+        {"connection refused", dial(syscall.ECONNREFUSED), false},
+        {"connection reset", dial(syscall.ECONNRESET), true},
+        {"stalled", fmt.Errorf("chunk 2: %w", errStalled), true},
+        {"short body", errShortBody, true},
+        {"other dial error", dial(syscall.EACCES), false},
+        {"plain error", errors.New("certificate signed by unknown authority"), false},
+    }
+
+    for _, test := range tests {
+        if got := isRetryable(test.err); got != test.want {
+            t.Errorf("%s: isRetryable = %v, want %v", test.name, got, test.want)
+        }
+    }
+}
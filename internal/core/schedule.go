@@ -0,0 +1,191 @@
+package core
+
+import (
+    "context"
+    "time"
+)
+
+// Clock tells the time. Schedules take one so they can be tested.
+type Clock interface {
+    Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+    return time.Now()
+}
+
+// SystemClock is the wall clock
+var SystemClock Clock = systemClock{}
+
+// BandwidthWindow limits the bandwidth during part of the day on some
+// weekdays. A window whose end is not after its start runs past midnight
+// into the next day.
+type BandwidthWindow struct {
+    Days  []time.Weekday
+    Start time.Duration // since local midnight
+    End   time.Duration // since local midnight
+    Limit int64         // bytes per second, 0 for unlimited
+}
+
+// BandwidthSchedule is a weekly list of bandwidth windows. Outside every
+// window DownloadConfig.MaxSpeed applies; where windows overlap the first
+// one wins.
+type BandwidthSchedule struct {
+    Windows []BandwidthWindow
+}
+
+// activeAt reports whether the window covers t
+func (w BandwidthWindow) activeAt(t time.Time) bool {
+    midnight := startOfDay(t)
+    offset := sinceMidnight(t)
+
+    if w.End > w.Start {
+        return w.hasDay(t.Weekday()) && offset >= w.Start && offset < w.End
+    }
+
+    // Runs past midnight: the evening part belongs to today, the early
+    // morning part to the day before
+    if offset >= w.Start {
+        return w.hasDay(t.Weekday())
+    }
+    return offset < w.End && w.hasDay(midnight.AddDate(0, 0, -1).Weekday())
+}
+
+func (w BandwidthWindow) hasDay(day time.Weekday) bool {
+    for _, d := range w.Days {
+        if d == day {
+            return true
+        }
+    }
+    return false
+}
+
+// LimitAt returns the limit in effect at t, or fallback outside every window
+func (s *BandwidthSchedule) LimitAt(t time.Time, fallback int64) int64 {
+    if s == nil {
+        return fallback
+    }
+
+    for _, w := range s.Windows {
+        if w.activeAt(t) {
+            return w.Limit
+        }
+    }
+    return fallback
+}
+
+// NextChange returns the first window boundary after t, or the zero time
+// when the schedule has no windows
+func (s *BandwidthSchedule) NextChange(t time.Time) time.Time {
+    var next time.Time
+    if s == nil {
+        return next
+    }
+
+    for _, w := range s.Windows {
+        if boundary := w.nextBoundary(t); !boundary.IsZero() && (next.IsZero() || boundary.Before(next)) {
+            next = boundary
+        }
+    }
+    return next
+}
+
+// nextBoundary returns the first start or end of the window after t, or the
+// zero time when the window has no days
+func (w BandwidthWindow) nextBoundary(t time.Time) time.Time {
+    var next time.Time
+
+    midnight := startOfDay(t)
+    end := w.End
+    if end <= w.Start {
+        end += 24 * time.Hour
+    }
+
+    // A boundary may belong to yesterday's window running past midnight
+    for day := -1; day <= 7; day++ {
+        date := midnight.AddDate(0, 0, day)
+        if !w.hasDay(date.Weekday()) {
+            continue
+        }
+
+        for _, boundary := range []time.Time{atOffset(date, w.Start), atOffset(date, end)} {
+            if boundary.After(t) && (next.IsZero() || boundary.Before(next)) {
+                next = boundary
+            }
+        }
+    }
+    return next
+}
+
+func startOfDay(t time.Time) time.Time {
+    year, month, day := t.Date()
+    return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// sinceMidnight returns the wall clock time of t as an offset from midnight,
+// so that 09:00 stays 09:00 across daylight saving changes
+func sinceMidnight(t time.Time) time.Duration {
+    hour, minute, second := t.Clock()
+    return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second +
+        time.Duration(t.Nanosecond())
+}
+
+// atOffset returns the time whose wall clock is offset after midnight of day
+func atOffset(day time.Time, offset time.Duration) time.Time {
+    year, month, date := day.Date()
+    return time.Date(year, month, date, 0, 0, 0, int(offset), day.Location())
+}
+
+// SetClock replaces the clock the bandwidth and queue schedules follow,
+// SystemClock by default. Call it before Start.
+func (dm *DownloadManager) SetClock(clock Clock) {
+    dm.clock = clock
+    dm.applyBandwidthLimit()
+}
+
+// SetBandwidthSchedule replaces the bandwidth schedule, nil to use MaxSpeed
+// around the clock
+func (dm *DownloadManager) SetBandwidthSchedule(schedule *BandwidthSchedule) error {
+    return dm.updateConfig(func(config *DownloadConfig) {
+        config.BandwidthSchedule = schedule
+    })
+}
+
+// BandwidthSchedule returns the current bandwidth schedule, nil when there is none
+func (dm *DownloadManager) BandwidthSchedule() *BandwidthSchedule {
+    return dm.Config().BandwidthSchedule
+}
+
+// applyBandwidthLimit sets the global limit the schedule asks for right now
+func (dm *DownloadManager) applyBandwidthLimit() {
+    config := dm.Config()
+    limit := config.BandwidthSchedule.LimitAt(dm.clock.Now(), config.MaxSpeed)
+    dm.limiter.SetLimit(limit)
+}
+
+// runBandwidthSchedule switches the global limit whenever a window of the
+// schedule begins or ends
+func (dm *DownloadManager) runBandwidthSchedule(ctx context.Context) {
+    for {
+        dm.applyBandwidthLimit()
+
+        // Check at least once a minute, in case the wall clock jumps
+        wait := time.Minute
+        now := dm.clock.Now()
+        if next := dm.BandwidthSchedule().NextChange(now); !next.IsZero() && next.Sub(now) < wait {
+            wait = next.Sub(now)
+        }
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case <-timer.C:
+        case <-dm.scheduleChanged:
+            timer.Stop()
+        }
+    }
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+    "testing"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+type fixedClock struct {
+    now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+    return c.now
+}
+
+// at returns a time in the week of Monday 2024-01-01
+func at(day time.Weekday, hour, minute int) time.Time {
+    offset := (int(day) + 6) % 7
+    return time.Date(2024, 1, 1+offset, hour, minute, 0, 0, time.UTC)
+}
+
+var weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+func TestBandwidthScheduleLimitAt(t *testing.T) {
+    office := &BandwidthSchedule{Windows: []BandwidthWindow{
+        {Days: weekdays, Start: 9 * time.Hour, End: 18 * time.Hour, Limit: 1 << 20},
+    }}
+    // 22:00 to 06:00, the morning part belongs to the day after
+    night := &BandwidthSchedule{Windows: []BandwidthWindow{
+        {Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 6 * time.Hour, Limit: 100},
+    }}
+    overlapping := &BandwidthSchedule{Windows: []BandwidthWindow{
+        {Days: weekdays, Start: 9 * time.Hour, End: 12 * time.Hour, Limit: 10},
+        {Days: weekdays, Start: 0, End: 0, Limit: 20},
+    }}
+
+    tests := []struct {
+        name     string
+        schedule *BandwidthSchedule
+        at       time.Time
+        want     int64
+    }{
+        {"no schedule", nil, at(time.Monday, 10, 0), -1},
+        {"inside", office, at(time.Monday, 10, 0), 1 << 20},
+        {"at the start", office, at(time.Friday, 9, 0), 1 << 20},
+        {"at the end", office, at(time.Friday, 18, 0), -1},
+        {"before", office, at(time.Monday, 8, 59), -1},
+        {"other day", office, at(time.Sunday, 10, 0), -1},
+        {"evening before midnight", night, at(time.Friday, 23, 0), 100},
+        {"morning after midnight", night, at(time.Saturday, 5, 59), 100},
+        {"morning ends", night, at(time.Saturday, 6, 0), -1},
+        {"morning of the day itself", night, at(time.Friday, 5, 0), -1},
+        {"evening of the day after", night, at(time.Saturday, 23, 0), -1},
+        {"first window wins", overlapping, at(time.Tuesday, 10, 0), 10},
+        {"whole day", overlapping, at(time.Tuesday, 13, 0), 20},
+    }
+
+    for _, test := range tests {
+        if got := test.schedule.LimitAt(test.at, -1); got != test.want {
+            t.Errorf("%s: LimitAt(%v) = %d, want %d", test.name, test.at, got, test.want)
+        }
+    }
+}
+
+func TestBandwidthScheduleNextChange(t *testing.T) {
+    office := &BandwidthSchedule{Windows: []BandwidthWindow{
+        {Days: weekdays, Start: 9 * time.Hour, End: 18 * time.Hour, Limit: 1 << 20},
+    }}
+    night := &BandwidthSchedule{Windows: []BandwidthWindow{
+        {Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 6 * time.Hour, Limit: 100},
+    }}
+
+    tests := []struct {
+        name     string
+        schedule *BandwidthSchedule
+        from     time.Time
+        want     time.Time
+    }{
+        {"no schedule", nil, at(time.Monday, 10, 0), time.Time{}},
+        {"no windows", &BandwidthSchedule{}, at(time.Monday, 10, 0), time.Time{}},
+        {"start", office, at(time.Monday, 8, 0), at(time.Monday, 9, 0)},
+        {"end", office, at(time.Monday, 10, 0), at(time.Monday, 18, 0)},
+        {"exactly at the start", office, at(time.Monday, 9, 0), at(time.Monday, 18, 0)},
+        {"over the weekend", office, at(time.Friday, 19, 0), at(time.Monday, 9, 0).AddDate(0, 0, 7)},
+        {"start before midnight", night, at(time.Friday, 12, 0), at(time.Friday, 22, 0)},
+        {"end after midnight", night, at(time.Friday, 23, 0), at(time.Saturday, 6, 0)},
+        {"end on the next morning", night, at(time.Saturday, 1, 0), at(time.Saturday, 6, 0)},
+        {"a week later", night, at(time.Saturday, 7, 0), at(time.Friday, 22, 0).AddDate(0, 0, 7)},
+    }
+
+    for _, test := range tests {
+        if got := test.schedule.NextChange(test.from); !got.Equal(test.want) {
+            t.Errorf("%s: NextChange(%v) = %v, want %v", test.name, test.from, got, test.want)
+        }
+    }
+}
+
+func TestSetClockAppliesSchedule(t *testing.T) {
+    dm, err := NewDownloadManager(storage.NewMemoryRepository())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := dm.SetBandwidthSchedule(&BandwidthSchedule{Windows: []BandwidthWindow{
+        {Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 6 * time.Hour, Limit: 100},
+    }}); err != nil {
+        t.Fatal(err)
+    }
+
+    dm.SetClock(fixedClock{at(time.Saturday, 2, 0)})
+    if limit := dm.limiter.Limit(); limit != 100 {
+        t.Errorf("limit inside the window = %d, want 100", limit)
+    }
+
+    dm.SetClock(fixedClock{at(time.Saturday, 12, 0)})
+    if limit := dm.limiter.Limit(); limit != dm.Config().MaxSpeed {
+        t.Errorf("limit outside the window = %d, want MaxSpeed %d", limit, dm.Config().MaxSpeed)
+    }
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+    "context"
+    "fmt"
+    "time"
+    "idm-go/internal/storage"
+)
+
+type QueueSchedule = storage.QueueSchedule
+
+// queueWindow turns a stored queue schedule into a weekly window
+func queueWindow(schedule *QueueSchedule) BandwidthWindow {
+    var days []time.Weekday
+    for day := time.Sunday; day <= time.Saturday; day++ {
+        if schedule.HasDay(day) {
+            days = append(days, day)
+        }
+    }
+
+    return BandwidthWindow{
+        Days:  days,
+        Start: time.Duration(schedule.StartTime) * time.Minute,
+        End:   time.Duration(schedule.StopTime) * time.Minute,
+    }
+}
+
+// queueScheduledAt reports, for every queue with an enabled schedule,
+// whether one of its schedules wants it running at t
+func queueScheduledAt(schedules []*QueueSchedule, t time.Time) map[int64]bool {
+    wanted := make(map[int64]bool)
+    for _, schedule := range schedules {
+        if !schedule.Enabled {
+            continue
+        }
+        wanted[schedule.QueueID] = wanted[schedule.QueueID] || queueWindow(schedule).activeAt(t)
+    }
+    return wanted
+}
+
+// schedulesByQueue groups the schedules by queue, keeping their order
+func schedulesByQueue(schedules []*QueueSchedule) map[int64][]QueueSchedule {
+    byQueue := make(map[int64][]QueueSchedule)
+    for _, schedule := range schedules {
+        byQueue[schedule.QueueID] = append(byQueue[schedule.QueueID], *schedule)
+    }
+    return byQueue
+}
+
+func sameSchedules(a, b []QueueSchedule) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// GetQueueSchedules returns the stored queue schedules
+func (dm *DownloadManager) GetQueueSchedules() ([]*QueueSchedule, error) {
+    return dm.store.GetQueueSchedules()
+}
+
+// SaveQueueSchedule stores a new or changed queue schedule. The queues are
+// brought in line with the schedules right away.
+func (dm *DownloadManager) SaveQueueSchedule(schedule *QueueSchedule) error {
+    if schedule.QueueID == 0 {
+        schedule.QueueID = storage.MainQueueID
+    }
+    if _, err := dm.queueByID(schedule.QueueID); err != nil {
+        return err
+    }
+    if schedule.Days&0x7f == 0 {
+        return fmt.Errorf("schedule needs at least one weekday")
+    }
+    if schedule.StartTime < 0 || schedule.StartTime >= 24*60 || schedule.StopTime < 0 || schedule.StopTime >= 24*60 {
+        return fmt.Errorf("schedule times must be within the day")
+    }
+    if schedule.StartTime == schedule.StopTime {
+        return fmt.Errorf("schedule start and stop times must differ")
+    }
+
+    id, err := dm.store.SaveQueueSchedule(schedule)
+    if err != nil {
+        return err
+    }
+    schedule.ID = id
+
+    dm.queueSchedulesUpdated()
+    return nil
+}
+
+// DeleteQueueSchedule removes a queue schedule
+func (dm *DownloadManager) DeleteQueueSchedule(id int64) error {
+    if err := dm.store.DeleteQueueSchedule(id); err != nil {
+        return err
+    }
+
+    dm.queueSchedulesUpdated()
+    return nil
+}
+
+func (dm *DownloadManager) queueSchedulesUpdated() {
+    select {
+    case dm.queueSchedulesChanged <- struct{}{}:
+    default:
+    }
+}
+
+// runQueueScheduler starts and stops queues when their schedules say so.
+// Queues with a schedule are brought in line with it at startup and when
+// their own schedules change; otherwise only the start and stop times
+// switch them, so a queue started by hand keeps running until the next
+// stop time.
+func (dm *DownloadManager) runQueueScheduler(ctx context.Context) {
+    last := dm.clock.Now()
+    // The schedules each queue was last brought in line with, none yet
+    applied := make(map[int64][]QueueSchedule)
+
+    for {
+        now := dm.clock.Now()
+
+        // On a database error the schedules are read again next round
+        schedules, err := dm.store.GetQueueSchedules()
+        if err == nil {
+            byQueue := schedulesByQueue(schedules)
+            current := queueScheduledAt(schedules, now)
+            previous := queueScheduledAt(schedules, last)
+            for queueID, running := range current {
+                changed := !sameSchedules(byQueue[queueID], applied[queueID])
+                if !changed && running == previous[queueID] {
+                    continue
+                }
+
+                // Schedules of removed queues are ignored
+                if running {
+                    dm.StartQueue(queueID)
+                } else {
+                    dm.StopQueue(queueID)
+                }
+            }
+            applied = byQueue
+            last = now
+        }
+
+        // Check at least once a minute, in case the wall clock jumps
+        wait := time.Minute
+        for _, schedule := range schedules {
+            if !schedule.Enabled {
+                continue
+            }
+            if next := queueWindow(schedule).nextBoundary(now); !next.IsZero() && next.Sub(now) < wait {
+                wait = next.Sub(now)
+            }
+        }
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case <-timer.C:
+        case <-dm.queueSchedulesChanged:
+            timer.Stop()
+        }
+    }
+}
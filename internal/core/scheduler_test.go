@@ -0,0 +1,85 @@
+package core
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "idm-go/internal/storage"
+)
+
+func queueRunning(t *testing.T, dm *DownloadManager, id int64) bool {
+    t.Helper()
+
+    queue, err := dm.queueByID(id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return queue.Running()
+}
+
+// waitUntil polls until condition holds, the scheduler runs on its own goroutine
+func waitUntil(t *testing.T, what string, condition func() bool) {
+    t.Helper()
+
+    deadline := time.Now().Add(5 * time.Second)
+    for !condition() {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting until %s", what)
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+// TestQueueSchedulerLeavesOtherQueues edits the schedule of one queue while
+// another queue runs by hand outside its window
+func TestQueueSchedulerLeavesOtherQueues(t *testing.T) {
+    dm, err := NewDownloadManager(storage.NewMemoryRepository())
+    if err != nil {
+        t.Fatal(err)
+    }
+    dm.SetClock(fixedClock{at(time.Monday, 12, 0)})
+
+    a := &QueueInfo{Name: "A", MaxConcurrent: 1}
+    b := &QueueInfo{Name: "B", MaxConcurrent: 1, Running: true}
+    for _, info := range []*QueueInfo{a, b} {
+        if err := dm.CreateQueue(info); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // B only runs at night
+    night := &QueueSchedule{QueueID: b.ID, StartTime: 60, StopTime: 120, Enabled: true}
+    night.SetDay(time.Monday, true)
+    if err := dm.SaveQueueSchedule(night); err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go dm.runQueueScheduler(ctx)
+
+    waitUntil(t, "B is stopped outside its window", func() bool { return !queueRunning(t, dm, b.ID) })
+    if err := dm.StartQueue(b.ID); err != nil {
+        t.Fatal(err)
+    }
+
+    // A runs around noon
+    noon := &QueueSchedule{QueueID: a.ID, StartTime: 11 * 60, StopTime: 13 * 60, Enabled: true}
+    noon.SetDay(time.Monday, true)
+    if err := dm.SaveQueueSchedule(noon); err != nil {
+        t.Fatal(err)
+    }
+
+    waitUntil(t, "A is started by its new schedule", func() bool { return queueRunning(t, dm, a.ID) })
+    if !queueRunning(t, dm, b.ID) {
+        t.Error("changing the schedule of A stopped B, which was started by hand")
+    }
+
+    // Changing B's own schedule brings it in line again
+    night.StopTime = 180
+    if err := dm.SaveQueueSchedule(night); err != nil {
+        t.Fatal(err)
+    }
+    waitUntil(t, "B is stopped after its schedule changed", func() bool { return !queueRunning(t, dm, b.ID) })
+}
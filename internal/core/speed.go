@@ -0,0 +1,73 @@
+package core
+
+import (
+    "math"
+    "time"
+)
+
+// speedSmoothing is how quickly a measured speed follows a change: after
+// this long it has gone about two thirds of the way
+const speedSmoothing = 5 * time.Second
+
+// speedMeter measures the rate of a growing byte count as an exponentially
+// weighted moving average, so a single slow or fast second does not make
+// the speed jump around
+type speedMeter struct {
+    rate   float64 // bytes per second
+    primed bool    // rate holds a measurement
+    total  int64
+    last   time.Time
+}
+
+// sample records the byte count at now and returns the smoothed rate in
+// bytes per second. The first sample only sets the starting point.
+func (m *speedMeter) sample(now time.Time, total int64) int64 {
+    if m.last.IsZero() || total < m.total {
+        // First sample, or the count started over
+        m.last, m.total = now, total
+        return int64(m.rate)
+    }
+
+    elapsed := now.Sub(m.last).Seconds()
+    if elapsed <= 0 {
+        return int64(m.rate)
+    }
+
+    current := float64(total-m.total) / elapsed
+    if m.primed {
+        alpha := 1 - math.Exp(-elapsed/speedSmoothing.Seconds())
+        m.rate += alpha * (current - m.rate)
+    } else {
+        m.rate = current
+        m.primed = true
+    }
+    m.last, m.total = now, total
+
+    return int64(math.Round(m.rate))
+}
+
+// estimateETA returns how long the rest of a download takes at speed, 0
+// when that cannot be told
+func estimateETA(size, downloaded, speed int64) time.Duration {
+    if size <= 0 || speed <= 0 || downloaded >= size {
+        return 0
+    }
+
+    seconds := math.Ceil(float64(size-downloaded) / float64(speed))
+    return time.Duration(seconds) * time.Second
+}
+
+// Speed returns the combined speed of all running downloads in bytes per
+// second
+func (dm *DownloadManager) Speed() int64 {
+    dm.mutex.RLock()
+    defer dm.mutex.RUnlock()
+
+    var total int64
+    for _, job := range dm.downloads {
+        job.mutex.RLock()
+        total += job.download.Speed
+        job.mutex.RUnlock()
+    }
+    return total
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+    "database/sql"
+)
+
+// Chunk represents a byte range of a download and how much of it is already on disk
+type Chunk struct {
+    ID         int64  `json:"id"`
+    DownloadID int64  `json:"download_id"`
+    Start      int64  `json:"start"`
+    End        int64  `json:"end"`
+    Downloaded int64  `json:"downloaded"`
+    Attempts   int    `json:"attempts"`
+    LastError  string `json:"last_error,omitempty"`
+    Speed      int64  `json:"speed,omitempty"` // bytes per second while downloading, not stored
+}
+
+// SaveChunks replaces the stored chunk layout of a download in a single transaction
+func SaveChunks(db *sql.DB, downloadID int64, chunks []*Chunk) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := replaceChunks(tx, downloadID, chunks); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+func replaceChunks(tx *sql.Tx, downloadID int64, chunks []*Chunk) error {
+    if _, err := tx.Exec("DELETE FROM chunks WHERE download_id = ?", downloadID); err != nil {
+        return err
+    }
+
+    query := `
+    INSERT INTO chunks (download_id, start_offset, end_offset, downloaded, attempts, last_error)
+    VALUES (?, ?, ?, ?, ?, ?)`
+
+    for _, chunk := range chunks {
+        if _, err := tx.Exec(query, downloadID, chunk.Start, chunk.End, chunk.Downloaded, chunk.Attempts, chunk.LastError); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func GetChunks(db *sql.DB, downloadID int64) ([]*Chunk, error) {
+    query := `
+    SELECT id, download_id, start_offset, end_offset, downloaded, attempts, last_error
+    FROM chunks WHERE download_id = ? ORDER BY start_offset`
+
+    rows, err := db.Query(query, downloadID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var chunks []*Chunk
+
+    for rows.Next() {
+        chunk := &Chunk{}
+        var lastError sql.NullString
+
+        err := rows.Scan(
+            &chunk.ID,
+            &chunk.DownloadID,
+            &chunk.Start,
+            &chunk.End,
+            &chunk.Downloaded,
+            &chunk.Attempts,
+            &lastError,
+        )
+
+        if err != nil {
+            return nil, err
+        }
+        chunk.LastError = lastError.String
+
+        chunks = append(chunks, chunk)
+    }
+
+    return chunks, rows.Err()
+}
+
+func DeleteChunks(db *sql.DB, downloadID int64) error {
+    query := "DELETE FROM chunks WHERE download_id = ?"
+    _, err := db.Exec(query, downloadID)
+    return err
+}
@@ -3,8 +3,6 @@ package storage
 import (
     "database/sql"
     "time"
-    
-    _ "github.com/mattn/go-sqlite3"
 )
 
 // DownloadStatus represents the status of a download
@@ -17,6 +15,8 @@ const (
     StatusCompleted
     StatusFailed
     StatusCancelled
+    StatusVerificationFailed
+    StatusBlocked // a download it waits for failed
 )
 
 func (s DownloadStatus) String() string {
@@ -33,11 +33,72 @@ func (s DownloadStatus) String() string {
         return "Failed"
     case StatusCancelled:
         return "Cancelled"
+    case StatusVerificationFailed:
+        return "Verification failed"
+    case StatusBlocked:
+        return "Blocked"
     default:
         return "Unknown"
     }
 }
 
+// ConflictPolicy decides what happens when the target file already exists
+type ConflictPolicy int
+
+const (
+    ConflictDefault ConflictPolicy = iota // use the manager configuration
+    ConflictRename
+    ConflictOverwrite
+    ConflictSkip
+    ConflictResume
+)
+
+func (p ConflictPolicy) String() string {
+    switch p {
+    case ConflictDefault:
+        return "Default"
+    case ConflictRename:
+        return "Rename"
+    case ConflictOverwrite:
+        return "Overwrite"
+    case ConflictSkip:
+        return "Skip if same size"
+    case ConflictResume:
+        return "Resume existing"
+    default:
+        return "Unknown"
+    }
+}
+
+// Priority decides where a download joins its queue
+type Priority int
+
+const (
+    PriorityLow Priority = iota - 1
+    PriorityNormal
+    PriorityHigh
+)
+
+func (p Priority) String() string {
+    switch p {
+    case PriorityLow:
+        return "Low"
+    case PriorityNormal:
+        return "Normal"
+    case PriorityHigh:
+        return "High"
+    default:
+        return "Unknown"
+    }
+}
+
+// Where the expected checksum of a download came from
+const (
+    ChecksumFromUser   = "user"
+    ChecksumFromServer = "server" // Repr-Digest, Digest or Content-MD5 header
+    ChecksumFromFile   = "file"   // published .sha256 or SHA256SUMS file
+)
+
 // Download represents a download item
 type Download struct {
     ID          int64         `json:"id"`
@@ -48,54 +109,39 @@ type Download struct {
     Downloaded  int64         `json:"downloaded"`
     Status      DownloadStatus `json:"status"`
     Speed       int64         `json:"speed"`
+    ETA         time.Duration `json:"eta,omitempty"` // time left at the current speed, not stored
     Progress    float64       `json:"progress"`
     CreatedAt   time.Time     `json:"created_at"`
     StartedAt   *time.Time    `json:"started_at,omitempty"`
     CompletedAt *time.Time    `json:"completed_at,omitempty"`
     Error       string        `json:"error,omitempty"`
     Chunks      int           `json:"chunks"`
+    Retries     int           `json:"retries"`
+    RetryError  string        `json:"retry_error,omitempty"`
+    Segments    []*Chunk      `json:"segments,omitempty"` // live chunk state, not stored in downloads
+    Conflict    ConflictPolicy `json:"conflict"`
+    ChecksumAlgorithm string  `json:"checksum_algorithm,omitempty"`
+    Checksum    string        `json:"checksum,omitempty"` // expected digest, lower-case hex
+    ChecksumSource string     `json:"checksum_source,omitempty"`
+    ETag        string        `json:"etag,omitempty"`
+    LastModified string       `json:"last_modified,omitempty"`
+    SpeedLimit  int64         `json:"speed_limit"` // bytes per second, 0 for no own limit
+    Weight      int           `json:"weight"`      // share of the global bandwidth relative to other downloads
+    QueueID     int64         `json:"queue_id"`
+    QueuePosition int         `json:"queue_position"` // order within the queue while waiting
+    Priority    Priority      `json:"priority"`
+    PackageID   int64         `json:"package_id,omitempty"` // 0 when not part of a package
 }
 
-func InitDB() (*sql.DB, error) {
-    db, err := sql.Open("sqlite3", "idm.db")
-    if err != nil {
-        return nil, err
-    }
-
-    if err := createTables(db); err != nil {
-        return nil, err
-    }
-
-    return db, nil
-}
-
-func createTables(db *sql.DB) error {
-    query := `
-    CREATE TABLE IF NOT EXISTS downloads (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        url TEXT NOT NULL,
-        filename TEXT NOT NULL,
-        path TEXT NOT NULL,
-        size INTEGER DEFAULT 0,
-        downloaded INTEGER DEFAULT 0,
-        status INTEGER DEFAULT 0,
-        speed INTEGER DEFAULT 0,
-        progress REAL DEFAULT 0,
-        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-        started_at DATETIME,
-        completed_at DATETIME,
-        error TEXT,
-        chunks INTEGER DEFAULT 1
-    );`
-
-    _, err := db.Exec(query)
-    return err
-}
+// DefaultWeight is the bandwidth weight of a download nobody changed
+const DefaultWeight = 1
 
 func SaveDownload(db *sql.DB, download *Download) (int64, error) {
     query := `
-    INSERT INTO downloads (url, filename, path, size, downloaded, status, chunks, created_at)
-    VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+    INSERT INTO downloads (url, filename, path, size, downloaded, status, chunks, created_at, completed_at, conflict_policy,
+                           checksum_algorithm, checksum, checksum_source, etag, last_modified, speed_limit, weight,
+                           queue_id, queue_position, priority, package_id)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
     result, err := db.Exec(query,
         download.URL,
@@ -106,6 +152,19 @@ func SaveDownload(db *sql.DB, download *Download) (int64, error) {
         int(download.Status),
         download.Chunks,
         download.CreatedAt,
+        download.CompletedAt,
+        int(download.Conflict),
+        download.ChecksumAlgorithm,
+        download.Checksum,
+        download.ChecksumSource,
+        download.ETag,
+        download.LastModified,
+        download.SpeedLimit,
+        download.Weight,
+        download.QueueID,
+        download.QueuePosition,
+        int(download.Priority),
+        download.PackageID,
     )
 
     if err != nil {
@@ -115,13 +174,20 @@ func SaveDownload(db *sql.DB, download *Download) (int64, error) {
     return result.LastInsertId()
 }
 
+// UpdateDownload leaves the queue columns alone, only SetDownloadQueue,
+// SaveQueueOrder and SetDownloadPriority change them, so that a stale copy
+// cannot move a download back
 func UpdateDownload(db *sql.DB, download *Download) error {
     query := `
     UPDATE downloads 
-    SET downloaded = ?, status = ?, speed = ?, progress = ?, started_at = ?, completed_at = ?, error = ?
+    SET filename = ?, size = ?, downloaded = ?, status = ?, speed = ?, progress = ?, started_at = ?, completed_at = ?,
+        error = ?, retries = ?, retry_error = ?, checksum_algorithm = ?, checksum = ?, checksum_source = ?,
+        etag = ?, last_modified = ?, speed_limit = ?, weight = ?
     WHERE id = ?`
 
     _, err := db.Exec(query,
+        download.Filename,
+        download.Size,
         download.Downloaded,
         int(download.Status),
         download.Speed,
@@ -129,6 +195,15 @@ func UpdateDownload(db *sql.DB, download *Download) error {
         download.StartedAt,
         download.CompletedAt,
         download.Error,
+        download.Retries,
+        download.RetryError,
+        download.ChecksumAlgorithm,
+        download.Checksum,
+        download.ChecksumSource,
+        download.ETag,
+        download.LastModified,
+        download.SpeedLimit,
+        download.Weight,
         download.ID,
     )
 
@@ -138,13 +213,16 @@ func UpdateDownload(db *sql.DB, download *Download) error {
 func GetDownload(db *sql.DB, id int64) (*Download, error) {
     query := `
     SELECT id, url, filename, path, size, downloaded, status, speed, progress, 
-           created_at, started_at, completed_at, error, chunks
+           created_at, started_at, completed_at, error, chunks, retries, retry_error, conflict_policy,
+           checksum_algorithm, checksum, checksum_source, etag, last_modified, speed_limit, weight,
+           queue_id, queue_position, priority, package_id
     FROM downloads WHERE id = ?`
 
     row := db.QueryRow(query, id)
 
     download := &Download{}
     var startedAt, completedAt sql.NullTime
+    var errorMessage, retryError, checksumAlgorithm, checksum, checksumSource, etag, lastModified sql.NullString
 
     err := row.Scan(
         &download.ID,
@@ -159,8 +237,22 @@ func GetDownload(db *sql.DB, id int64) (*Download, error) {
         &download.CreatedAt,
         &startedAt,
         &completedAt,
-        &download.Error,
+        &errorMessage,
         &download.Chunks,
+        &download.Retries,
+        &retryError,
+        (*int)(&download.Conflict),
+        &checksumAlgorithm,
+        &checksum,
+        &checksumSource,
+        &etag,
+        &lastModified,
+        &download.SpeedLimit,
+        &download.Weight,
+        &download.QueueID,
+        &download.QueuePosition,
+        (*int)(&download.Priority),
+        &download.PackageID,
     )
 
     if err != nil {
@@ -173,17 +265,32 @@ func GetDownload(db *sql.DB, id int64) (*Download, error) {
     if completedAt.Valid {
         download.CompletedAt = &completedAt.Time
     }
+    download.Error = errorMessage.String
+    download.RetryError = retryError.String
+    download.ChecksumAlgorithm = checksumAlgorithm.String
+    download.Checksum = checksum.String
+    download.ChecksumSource = checksumSource.String
+    download.ETag = etag.String
+    download.LastModified = lastModified.String
 
     return download, nil
 }
 
 func GetAllDownloads(db *sql.DB) ([]*Download, error) {
+    return queryDownloads(db, "ORDER BY created_at DESC")
+}
+
+// queryDownloads returns the downloads selected by a WHERE and ORDER BY
+// clause
+func queryDownloads(db *sql.DB, clause string, args ...interface{}) ([]*Download, error) {
     query := `
     SELECT id, url, filename, path, size, downloaded, status, speed, progress, 
-           created_at, started_at, completed_at, error, chunks
-    FROM downloads ORDER BY created_at DESC`
+           created_at, started_at, completed_at, error, chunks, retries, retry_error, conflict_policy,
+           checksum_algorithm, checksum, checksum_source, etag, last_modified, speed_limit, weight,
+           queue_id, queue_position, priority, package_id
+    FROM downloads ` + clause
 
-    rows, err := db.Query(query)
+    rows, err := db.Query(query, args...)
     if err != nil {
         return nil, err
     }
@@ -194,6 +301,7 @@ func GetAllDownloads(db *sql.DB) ([]*Download, error) {
     for rows.Next() {
         download := &Download{}
         var startedAt, completedAt sql.NullTime
+        var errorMessage, retryError, checksumAlgorithm, checksum, checksumSource, etag, lastModified sql.NullString
 
         err := rows.Scan(
             &download.ID,
@@ -208,8 +316,22 @@ func GetAllDownloads(db *sql.DB) ([]*Download, error) {
             &download.CreatedAt,
             &startedAt,
             &completedAt,
-            &download.Error,
+            &errorMessage,
             &download.Chunks,
+            &download.Retries,
+            &retryError,
+            (*int)(&download.Conflict),
+            &checksumAlgorithm,
+            &checksum,
+            &checksumSource,
+            &etag,
+            &lastModified,
+            &download.SpeedLimit,
+            &download.Weight,
+            &download.QueueID,
+            &download.QueuePosition,
+            (*int)(&download.Priority),
+            &download.PackageID,
         )
 
         if err != nil {
@@ -222,6 +344,13 @@ func GetAllDownloads(db *sql.DB) ([]*Download, error) {
         if completedAt.Valid {
             download.CompletedAt = &completedAt.Time
         }
+        download.Error = errorMessage.String
+        download.RetryError = retryError.String
+        download.ChecksumAlgorithm = checksumAlgorithm.String
+        download.Checksum = checksum.String
+        download.ChecksumSource = checksumSource.String
+        download.ETag = etag.String
+        download.LastModified = lastModified.String
 
         downloads = append(downloads, download)
     }
@@ -229,8 +358,24 @@ func GetAllDownloads(db *sql.DB) ([]*Download, error) {
     return downloads, rows.Err()
 }
 
+// DeleteDownload removes a download with its chunks and dependencies
 func DeleteDownload(db *sql.DB, id int64) error {
-    query := "DELETE FROM downloads WHERE id = ?"
-    _, err := db.Exec(query, id)
-    return err
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    queries := []string{
+        "DELETE FROM chunks WHERE download_id = ?",
+        "DELETE FROM dependencies WHERE download_id = ?1 OR depends_on = ?1",
+        "DELETE FROM downloads WHERE id = ?",
+    }
+    for _, query := range queries {
+        if _, err := tx.Exec(query, id); err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit()
 }
\ No newline at end of file
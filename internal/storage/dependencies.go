@@ -0,0 +1,48 @@
+package storage
+
+import (
+    "database/sql"
+)
+
+// AddDependency makes a download wait until another one has completed
+func AddDependency(db *sql.DB, downloadID, dependsOn int64) error {
+    query := "INSERT OR IGNORE INTO dependencies (download_id, depends_on) VALUES (?, ?)"
+    _, err := db.Exec(query, downloadID, dependsOn)
+    return err
+}
+
+func RemoveDependency(db *sql.DB, downloadID, dependsOn int64) error {
+    query := "DELETE FROM dependencies WHERE download_id = ? AND depends_on = ?"
+    _, err := db.Exec(query, downloadID, dependsOn)
+    return err
+}
+
+// GetDependencies returns the IDs of the downloads a download waits for
+func GetDependencies(db *sql.DB, downloadID int64) ([]int64, error) {
+    return queryIDs(db, "SELECT depends_on FROM dependencies WHERE download_id = ? ORDER BY depends_on", downloadID)
+}
+
+// GetDependents returns the IDs of the downloads waiting for a download
+func GetDependents(db *sql.DB, downloadID int64) ([]int64, error) {
+    return queryIDs(db, "SELECT download_id FROM dependencies WHERE depends_on = ? ORDER BY download_id", downloadID)
+}
+
+func queryIDs(db *sql.DB, query string, args ...interface{}) ([]int64, error) {
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int64
+
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+
+    return ids, rows.Err()
+}
@@ -0,0 +1,15 @@
+package storage
+
+// The SQLite repository needs cgo. A pure Go driver behind a build tag is
+// left for when modernc.org/sqlite is a dependency; without cgo the
+// memory repository still works.
+
+import (
+    _ "github.com/mattn/go-sqlite3"
+)
+
+const driverName = "sqlite3"
+
+// WAL lets the UI read while progress is written, and a busy connection
+// waits for the lock instead of failing right away
+const connectionOptions = "?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000"
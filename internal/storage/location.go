@@ -0,0 +1,197 @@
+package storage
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+// appName names the directories the application keeps its files in
+const appName = "idm-go"
+
+// DataDirEnv overrides the data directory, like the --data-dir flag
+const DataDirEnv = "IDM_DATA_DIR"
+
+// legacyDatabase is where the database was kept before it moved to the data
+// directory: the working directory, whichever that was at the time
+const legacyDatabase = "idm.db"
+
+// Location is where the application keeps its files
+type Location struct {
+    DataDir   string // the database and the lock file
+    ConfigDir string // the settings
+}
+
+// ResolveLocation picks the directories to use. A non-empty dataDir, from
+// the command line, wins over $IDM_DATA_DIR; either keeps everything,
+// settings included, in that one directory. Otherwise the XDG base
+// directories are followed: $XDG_DATA_HOME/idm-go for the data and
+// $XDG_CONFIG_HOME/idm-go for the settings.
+func ResolveLocation(dataDir string) (*Location, error) {
+    if dataDir == "" {
+        dataDir = os.Getenv(DataDirEnv)
+    }
+
+    var location *Location
+    if dataDir != "" {
+        dir, err := filepath.Abs(dataDir)
+        if err != nil {
+            return nil, err
+        }
+        location = &Location{DataDir: dir, ConfigDir: dir}
+    } else {
+        dataHome, err := xdgDir("XDG_DATA_HOME", ".local", "share")
+        if err != nil {
+            return nil, err
+        }
+        configHome, err := xdgDir("XDG_CONFIG_HOME", ".config")
+        if err != nil {
+            return nil, err
+        }
+        location = &Location{
+            DataDir:   filepath.Join(dataHome, appName),
+            ConfigDir: filepath.Join(configHome, appName),
+        }
+    }
+
+    for _, dir := range []string{location.DataDir, location.ConfigDir} {
+        if err := os.MkdirAll(dir, 0700); err != nil {
+            return nil, err
+        }
+    }
+
+    return location, nil
+}
+
+// xdgDir returns the directory named by an XDG variable, or its default
+// under the home directory. Relative paths are invalid per the spec and
+// ignored.
+func xdgDir(variable string, fallback ...string) (string, error) {
+    if dir := os.Getenv(variable); filepath.IsAbs(dir) {
+        return dir, nil
+    }
+
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return "", fmt.Errorf("finding the home directory for $%s: %w", variable, err)
+    }
+
+    return filepath.Join(append([]string{home}, fallback...)...), nil
+}
+
+func (l *Location) DatabasePath() string {
+    return filepath.Join(l.DataDir, "idm.db")
+}
+
+func (l *Location) SettingsPath() string {
+    return filepath.Join(l.ConfigDir, "settings.json")
+}
+
+func (l *Location) lockPath() string {
+    return filepath.Join(l.DataDir, "idm.lock")
+}
+
+// MigrateLegacyDatabase moves an idm.db from the working directory into the
+// data directory, unless there already is a database there. It reports
+// whether anything was moved. Call it with the lock held.
+func (l *Location) MigrateLegacyDatabase() (bool, error) {
+    legacy, err := filepath.Abs(legacyDatabase)
+    if err != nil {
+        return false, err
+    }
+    target := l.DatabasePath()
+
+    if legacy == target {
+        return false, nil
+    }
+    if _, err := os.Stat(legacy); err != nil {
+        return false, nil
+    }
+    if _, err := os.Stat(target); err == nil {
+        return false, nil
+    }
+
+    // The WAL may hold committed changes not yet in the main file, so it
+    // goes along; the database comes last, so an interrupted move is
+    // retried on the next start
+    for _, suffix := range []string{"-wal", "-shm", ""} {
+        if err := moveFile(legacy+suffix, target+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+            return false, fmt.Errorf("moving %s to %s: %w", legacy+suffix, target+suffix, err)
+        }
+    }
+
+    return true, nil
+}
+
+// moveFile renames a file, copying it when the target is on another file
+// system
+func moveFile(from, to string) error {
+    if err := os.Rename(from, to); err == nil {
+        return nil
+    }
+
+    source, err := os.Open(from)
+    if err != nil {
+        return err
+    }
+    defer source.Close()
+
+    target, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(target, source); err != nil {
+        target.Close()
+        os.Remove(to)
+        return err
+    }
+    if err := target.Sync(); err != nil {
+        target.Close()
+        os.Remove(to)
+        return err
+    }
+    if err := target.Close(); err != nil {
+        os.Remove(to)
+        return err
+    }
+
+    source.Close()
+    return os.Remove(from)
+}
+
+// ErrLocked is returned by Lock while another instance uses the data
+// directory
+var ErrLocked = errors.New("the data directory is in use by another instance")
+
+// InstanceLock keeps other instances away from a data directory until it is
+// released. The operating system releases it if the process dies.
+type InstanceLock struct {
+    file *os.File
+}
+
+// Lock takes the lock file of the data directory, or fails with ErrLocked
+func (l *Location) Lock() (*InstanceLock, error) {
+    file, err := os.OpenFile(l.lockPath(), os.O_RDWR|os.O_CREATE, 0600)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := lockFile(file); err != nil {
+        file.Close()
+        return nil, err
+    }
+
+    // The PID helps whoever finds the directory locked
+    file.Truncate(0)
+    fmt.Fprintf(file, "%d\n", os.Getpid())
+
+    return &InstanceLock{file: file}, nil
+}
+
+func (lock *InstanceLock) Release() error {
+    // Closing the file drops the lock; the file itself stays, removing it
+    // could race with another instance that just opened it
+    return lock.file.Close()
+}
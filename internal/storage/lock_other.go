@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package storage
+
+import (
+    "os"
+)
+
+// lockFile cannot lock here; a second instance is not kept out
+func lockFile(file *os.File) error {
+    return nil
+}
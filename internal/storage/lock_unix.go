@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package storage
+
+import (
+    "errors"
+    "os"
+    "syscall"
+)
+
+func lockFile(file *os.File) error {
+    err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+    if errors.Is(err, syscall.EWOULDBLOCK) {
+        return ErrLocked
+    }
+    return err
+}
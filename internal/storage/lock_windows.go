@@ -0,0 +1,20 @@
+//go:build windows
+
+package storage
+
+import (
+    "errors"
+    "os"
+
+    "golang.org/x/sys/windows"
+)
+
+func lockFile(file *os.File) error {
+    var overlapped windows.Overlapped
+    err := windows.LockFileEx(windows.Handle(file.Fd()),
+        windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &overlapped)
+    if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+        return ErrLocked
+    }
+    return err
+}
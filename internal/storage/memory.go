@@ -0,0 +1,532 @@
+package storage
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+)
+
+// MemoryRepository keeps everything in memory, for embedding the download
+// manager and for tests. It behaves like the SQLite repository: callers get
+// copies, and the fields UpdateDownload leaves alone there stay alone here.
+type MemoryRepository struct {
+    mutex        sync.Mutex
+    downloads    map[int64]*Download
+    chunks       map[int64][]*Chunk
+    dependencies map[int64]map[int64]bool // download ID to the IDs it waits for
+    queues       map[int64]*QueueInfo
+    schedules    map[int64]*QueueSchedule
+    packages     map[int64]*Package
+    settings     map[string]string
+    lastID       int64
+}
+
+var _ Repository = (*MemoryRepository)(nil)
+
+// NewMemoryRepository returns an empty repository with the main queue, like
+// a new database
+func NewMemoryRepository() *MemoryRepository {
+    return &MemoryRepository{
+        downloads:    make(map[int64]*Download),
+        chunks:       make(map[int64][]*Chunk),
+        dependencies: make(map[int64]map[int64]bool),
+        queues: map[int64]*QueueInfo{
+            MainQueueID: {ID: MainQueueID, Name: "Main", MaxConcurrent: 3, Running: true},
+        },
+        schedules: make(map[int64]*QueueSchedule),
+        packages:  make(map[int64]*Package),
+        settings:  make(map[string]string),
+        lastID:    MainQueueID,
+    }
+}
+
+func (r *MemoryRepository) Close() error {
+    return nil
+}
+
+// nextID hands out IDs that are never reused, one sequence for all tables
+func (r *MemoryRepository) nextID() int64 {
+    r.lastID++
+    return r.lastID
+}
+
+func (r *MemoryRepository) SaveDownload(download *Download) (int64, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    stored := copyStoredDownload(download)
+    stored.ID = r.nextID()
+    r.downloads[stored.ID] = stored
+
+    return stored.ID, nil
+}
+
+// UpdateDownload leaves the queue and package fields alone, see the SQLite
+// version
+func (r *MemoryRepository) UpdateDownload(download *Download) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    stored, ok := r.downloads[download.ID]
+    if !ok {
+        return nil
+    }
+
+    updated := copyStoredDownload(download)
+    updated.URL = stored.URL
+    updated.Path = stored.Path
+    updated.Chunks = stored.Chunks
+    updated.CreatedAt = stored.CreatedAt
+    updated.Conflict = stored.Conflict
+    updated.QueueID = stored.QueueID
+    updated.QueuePosition = stored.QueuePosition
+    updated.Priority = stored.Priority
+    updated.PackageID = stored.PackageID
+    r.downloads[download.ID] = updated
+
+    return nil
+}
+
+func (r *MemoryRepository) GetDownload(id int64) (*Download, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    stored, ok := r.downloads[id]
+    if !ok {
+        return nil, fmt.Errorf("download %d: %w", id, ErrNotFound)
+    }
+
+    return copyStoredDownload(stored), nil
+}
+
+// GetAllDownloads returns the newest downloads first
+func (r *MemoryRepository) GetAllDownloads() ([]*Download, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    return r.sortedDownloads(), nil
+}
+
+func (r *MemoryRepository) sortedDownloads() []*Download {
+    var downloads []*Download
+    for _, stored := range r.downloads {
+        downloads = append(downloads, copyStoredDownload(stored))
+    }
+
+    sort.Slice(downloads, func(i, j int) bool {
+        if !downloads[i].CreatedAt.Equal(downloads[j].CreatedAt) {
+            return downloads[i].CreatedAt.After(downloads[j].CreatedAt)
+        }
+        return downloads[i].ID > downloads[j].ID
+    })
+
+    return downloads
+}
+
+func (r *MemoryRepository) DeleteDownload(id int64) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    delete(r.downloads, id)
+    delete(r.chunks, id)
+    r.deleteDependencies(id)
+
+    return nil
+}
+
+func (r *MemoryRepository) SaveProgress(batch []*Progress) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    for _, progress := range batch {
+        stored, ok := r.downloads[progress.DownloadID]
+        if !ok {
+            continue
+        }
+        stored.Downloaded = progress.Downloaded
+        stored.Progress = progress.Progress
+        stored.Speed = progress.Speed
+        if progress.Chunks != nil {
+            r.replaceChunks(progress.DownloadID, progress.Chunks)
+        }
+    }
+
+    return nil
+}
+
+func (r *MemoryRepository) SaveChunks(downloadID int64, chunks []*Chunk) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    r.replaceChunks(downloadID, chunks)
+    return nil
+}
+
+func (r *MemoryRepository) replaceChunks(downloadID int64, chunks []*Chunk) {
+    var stored []*Chunk
+    for _, chunk := range chunks {
+        c := *chunk
+        c.ID = r.nextID()
+        c.DownloadID = downloadID
+        c.Speed = 0
+        stored = append(stored, &c)
+    }
+
+    sort.SliceStable(stored, func(i, j int) bool {
+        return stored[i].Start < stored[j].Start
+    })
+
+    if len(stored) == 0 {
+        delete(r.chunks, downloadID)
+        return
+    }
+    r.chunks[downloadID] = stored
+}
+
+// GetChunks returns the chunks of a download by offset
+func (r *MemoryRepository) GetChunks(downloadID int64) ([]*Chunk, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    var chunks []*Chunk
+    for _, chunk := range r.chunks[downloadID] {
+        c := *chunk
+        chunks = append(chunks, &c)
+    }
+
+    return chunks, nil
+}
+
+func (r *MemoryRepository) DeleteChunks(downloadID int64) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    delete(r.chunks, downloadID)
+    return nil
+}
+
+func (r *MemoryRepository) AddDependency(downloadID, dependsOn int64) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    if r.dependencies[downloadID] == nil {
+        r.dependencies[downloadID] = make(map[int64]bool)
+    }
+    r.dependencies[downloadID][dependsOn] = true
+
+    return nil
+}
+
+func (r *MemoryRepository) RemoveDependency(downloadID, dependsOn int64) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    delete(r.dependencies[downloadID], dependsOn)
+    return nil
+}
+
+func (r *MemoryRepository) GetDependencies(downloadID int64) ([]int64, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    var ids []int64
+    for id := range r.dependencies[downloadID] {
+        ids = append(ids, id)
+    }
+
+    return sortedIDs(ids), nil
+}
+
+func (r *MemoryRepository) GetDependents(downloadID int64) ([]int64, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    var ids []int64
+    for id, dependsOn := range r.dependencies {
+        if dependsOn[downloadID] {
+            ids = append(ids, id)
+        }
+    }
+
+    return sortedIDs(ids), nil
+}
+
+// deleteDependencies removes every link from or to a download
+func (r *MemoryRepository) deleteDependencies(downloadID int64) {
+    delete(r.dependencies, downloadID)
+    for _, dependsOn := range r.dependencies {
+        delete(dependsOn, downloadID)
+    }
+}
+
+func (r *MemoryRepository) SaveQueue(queue *QueueInfo) (int64, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    for _, other := range r.queues {
+        if other.Name == queue.Name && other.ID != queue.ID {
+            return 0, fmt.Errorf("queue %q already exists", queue.Name)
+        }
+    }
+
+    stored := *queue
+    if stored.ID == 0 {
+        stored.ID = r.nextID()
+    } else if _, ok := r.queues[stored.ID]; !ok {
+        return stored.ID, nil
+    }
+    r.queues[stored.ID] = &stored
+
+    return stored.ID, nil
+}
+
+// GetQueues returns the queues by ID
+func (r *MemoryRepository) GetQueues() ([]*QueueInfo, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    var queues []*QueueInfo
+    for _, queue := range r.queues {
+        q := *queue
+        queues = append(queues, &q)
+    }
+
+    sort.Slice(queues, func(i, j int) bool {
+        return queues[i].ID < queues[j].ID
+    })
+
+    return queues, nil
+}
+
+// DeleteQueue removes a queue and its schedules. Its downloads move to the
+// main queue, which itself cannot be deleted.
+func (r *MemoryRepository) DeleteQueue(id int64) error {
+    if id == MainQueueID {
+        return fmt.Errorf("the main queue cannot be deleted")
+    }
+
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    for _, download := range r.downloads {
+        if download.QueueID == id {
+            download.QueueID = MainQueueID
+        }
+    }
+    for scheduleID, schedule := range r.schedules {
+        if schedule.QueueID == id {
+            delete(r.schedules, scheduleID)
+        }
+    }
+    delete(r.queues, id)
+
+    return nil
+}
+
+func (r *MemoryRepository) SetDownloadQueue(downloadID, queueID int64) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    if download, ok := r.downloads[downloadID]; ok {
+        download.QueueID = queueID
+    }
+    return nil
+}
+
+func (r *MemoryRepository) SaveQueueOrder(downloadIDs []int64) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    for position, id := range downloadIDs {
+        if download, ok := r.downloads[id]; ok {
+            download.QueuePosition = position
+        }
+    }
+    return nil
+}
+
+func (r *MemoryRepository) SetDownloadPriority(downloadID int64, priority Priority) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    if download, ok := r.downloads[downloadID]; ok {
+        download.Priority = priority
+    }
+    return nil
+}
+
+func (r *MemoryRepository) SaveQueueSchedule(schedule *QueueSchedule) (int64, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    stored := *schedule
+    if stored.ID == 0 {
+        stored.ID = r.nextID()
+    } else if _, ok := r.schedules[stored.ID]; !ok {
+        return stored.ID, nil
+    }
+    r.schedules[stored.ID] = &stored
+
+    return stored.ID, nil
+}
+
+// GetQueueSchedules returns the schedules by queue and start time
+func (r *MemoryRepository) GetQueueSchedules() ([]*QueueSchedule, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    var schedules []*QueueSchedule
+    for _, schedule := range r.schedules {
+        s := *schedule
+        schedules = append(schedules, &s)
+    }
+
+    sort.Slice(schedules, func(i, j int) bool {
+        a, b := schedules[i], schedules[j]
+        if a.QueueID != b.QueueID {
+            return a.QueueID < b.QueueID
+        }
+        if a.StartTime != b.StartTime {
+            return a.StartTime < b.StartTime
+        }
+        return a.ID < b.ID
+    })
+
+    return schedules, nil
+}
+
+func (r *MemoryRepository) DeleteQueueSchedule(id int64) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    delete(r.schedules, id)
+    return nil
+}
+
+func (r *MemoryRepository) SavePackage(pkg *Package) (int64, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    stored := *pkg
+    stored.ID = r.nextID()
+    r.packages[stored.ID] = &stored
+
+    return stored.ID, nil
+}
+
+func (r *MemoryRepository) GetPackage(id int64) (*Package, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    stored, ok := r.packages[id]
+    if !ok {
+        return nil, fmt.Errorf("package %d: %w", id, ErrNotFound)
+    }
+
+    pkg := *stored
+    return &pkg, nil
+}
+
+// GetPackages returns the newest packages first
+func (r *MemoryRepository) GetPackages() ([]*Package, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    var packages []*Package
+    for _, stored := range r.packages {
+        pkg := *stored
+        packages = append(packages, &pkg)
+    }
+
+    sort.Slice(packages, func(i, j int) bool {
+        if !packages[i].CreatedAt.Equal(packages[j].CreatedAt) {
+            return packages[i].CreatedAt.After(packages[j].CreatedAt)
+        }
+        return packages[i].ID > packages[j].ID
+    })
+
+    return packages, nil
+}
+
+// GetPackageDownloads returns the downloads of a package in the order they
+// were added
+func (r *MemoryRepository) GetPackageDownloads(packageID int64) ([]*Download, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    var members []*Download
+    for _, stored := range r.downloads {
+        if stored.PackageID == packageID {
+            members = append(members, copyStoredDownload(stored))
+        }
+    }
+
+    sort.Slice(members, func(i, j int) bool {
+        if !members[i].CreatedAt.Equal(members[j].CreatedAt) {
+            return members[i].CreatedAt.Before(members[j].CreatedAt)
+        }
+        return members[i].ID < members[j].ID
+    })
+
+    return members, nil
+}
+
+// DeletePackage removes a package. Its downloads stay, on their own.
+func (r *MemoryRepository) DeletePackage(id int64) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    for _, download := range r.downloads {
+        if download.PackageID == id {
+            download.PackageID = 0
+        }
+    }
+    delete(r.packages, id)
+
+    return nil
+}
+
+// copyStoredDownload copies what a download row holds: the live fields
+// that are not stored are left out
+func copyStoredDownload(download *Download) *Download {
+    stored := *download
+    stored.ETA = 0
+    stored.Segments = nil
+    if download.StartedAt != nil {
+        startedAt := *download.StartedAt
+        stored.StartedAt = &startedAt
+    }
+    if download.CompletedAt != nil {
+        completedAt := *download.CompletedAt
+        stored.CompletedAt = &completedAt
+    }
+    return &stored
+}
+
+func sortedIDs(ids []int64) []int64 {
+    sort.Slice(ids, func(i, j int) bool {
+        return ids[i] < ids[j]
+    })
+    return ids
+}
+
+func (r *MemoryRepository) GetSettings() (map[string]string, error) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    settings := make(map[string]string, len(r.settings))
+    for key, value := range r.settings {
+        settings[key] = value
+    }
+    return settings, nil
+}
+
+func (r *MemoryRepository) SaveSettings(settings map[string]string) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    for key, value := range settings {
+        r.settings[key] = value
+    }
+    return nil
+}
@@ -0,0 +1,281 @@
+package storage
+
+import (
+    "database/sql"
+    "fmt"
+    "os"
+)
+
+// migration takes the schema from version-1 to version. Migrations that
+// delete or rewrite data are destructive, the database file is backed up
+// before they run.
+type migration struct {
+    version     int
+    description string
+    destructive bool
+    up          func(tx *sql.Tx) error
+}
+
+// migrations in the order they are applied. Append new ones at the end and
+// never change one that has been released: its version is recorded in the
+// databases it ran on.
+var migrations = []migration{
+    {1, "initial schema", false, createTables},
+    {2, "remove chunks and dependencies of deleted downloads", true, removeOrphans},
+}
+
+// SchemaVersion is the schema version this build writes
+func SchemaVersion() int {
+    return migrations[len(migrations)-1].version
+}
+
+// migrate brings the schema up to date, one transaction per migration, so a
+// failed step leaves the database at the previous version. The version is
+// kept in PRAGMA user_version.
+func migrate(db *sql.DB, path string) error {
+    version, err := schemaVersion(db)
+    if err != nil {
+        return err
+    }
+    if version > SchemaVersion() {
+        return fmt.Errorf("database schema version %d is newer than %d, the version this build supports; update the application", version, SchemaVersion())
+    }
+
+    // A new database has nothing to lose
+    existing, err := hasTable(db, "downloads")
+    if err != nil {
+        return err
+    }
+
+    var pending []migration
+    destructive := false
+    for _, m := range migrations {
+        if m.version > version {
+            pending = append(pending, m)
+            destructive = destructive || m.destructive
+        }
+    }
+
+    // One copy of the database as it is, before any migration touches it,
+    // named after the schema version it holds
+    if destructive && existing {
+        if err := backup(db, fmt.Sprintf("%s.v%d.bak", path, version)); err != nil {
+            return fmt.Errorf("backing up schema version %d: %w", version, err)
+        }
+    }
+
+    for _, m := range pending {
+        if err := applyMigration(db, m); err != nil {
+            return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+        }
+    }
+
+    return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := m.up(tx); err != nil {
+        return err
+    }
+    // user_version is part of the database header, so it commits with the
+    // migration or not at all
+    if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version)); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+    var version int
+    err := db.QueryRow("PRAGMA user_version").Scan(&version)
+    return version, err
+}
+
+func hasTable(db *sql.DB, name string) (bool, error) {
+    var count int
+    err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+    return count > 0, err
+}
+
+// backup writes a consistent copy of the database to path. VACUUM INTO
+// includes what is still in the WAL, unlike copying the file.
+func backup(db *sql.DB, path string) error {
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    _, err := db.Exec("VACUUM INTO ?", path)
+    return err
+}
+
+// createTables is the schema before versioning. Databases from that time
+// may lack columns added later, hence addMissingColumns.
+func createTables(tx *sql.Tx) error {
+    query := `
+    CREATE TABLE IF NOT EXISTS downloads (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        url TEXT NOT NULL,
+        filename TEXT NOT NULL,
+        path TEXT NOT NULL,
+        size INTEGER DEFAULT 0,
+        downloaded INTEGER DEFAULT 0,
+        status INTEGER DEFAULT 0,
+        speed INTEGER DEFAULT 0,
+        progress REAL DEFAULT 0,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        started_at DATETIME,
+        completed_at DATETIME,
+        error TEXT,
+        chunks INTEGER DEFAULT 1,
+        retries INTEGER DEFAULT 0,
+        retry_error TEXT,
+        conflict_policy INTEGER DEFAULT 0,
+        checksum_algorithm TEXT,
+        checksum TEXT,
+        checksum_source TEXT,
+        etag TEXT,
+        last_modified TEXT,
+        speed_limit INTEGER DEFAULT 0,
+        weight INTEGER DEFAULT 1,
+        queue_id INTEGER DEFAULT 1,
+        queue_position INTEGER DEFAULT 0,
+        priority INTEGER DEFAULT 0,
+        package_id INTEGER DEFAULT 0
+    );
+
+    CREATE TABLE IF NOT EXISTS chunks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        download_id INTEGER NOT NULL,
+        start_offset INTEGER NOT NULL,
+        end_offset INTEGER NOT NULL,
+        downloaded INTEGER DEFAULT 0,
+        attempts INTEGER DEFAULT 0,
+        last_error TEXT
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_chunks_download ON chunks(download_id);
+
+    CREATE TABLE IF NOT EXISTS queue_schedules (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        queue_id INTEGER NOT NULL DEFAULT 1,
+        days INTEGER NOT NULL DEFAULT 0,
+        start_time INTEGER NOT NULL,
+        stop_time INTEGER NOT NULL,
+        enabled BOOLEAN DEFAULT 1
+    );
+
+    CREATE TABLE IF NOT EXISTS queues (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL UNIQUE,
+        max_concurrent INTEGER DEFAULT 3,
+        max_speed INTEGER DEFAULT 0,
+        running BOOLEAN DEFAULT 1
+    );
+
+    INSERT OR IGNORE INTO queues (id, name) VALUES (1, 'Main');
+
+    CREATE TABLE IF NOT EXISTS dependencies (
+        download_id INTEGER NOT NULL,
+        depends_on INTEGER NOT NULL,
+        PRIMARY KEY (download_id, depends_on)
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_dependencies_depends_on ON dependencies(depends_on);
+
+    CREATE TABLE IF NOT EXISTS packages (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );`
+
+    if _, err := tx.Exec(query); err != nil {
+        return err
+    }
+
+    if err := addMissingColumns(tx, "downloads", [][2]string{
+        {"retries", "INTEGER DEFAULT 0"},
+        {"retry_error", "TEXT"},
+        {"conflict_policy", "INTEGER DEFAULT 0"},
+        {"checksum_algorithm", "TEXT"},
+        {"checksum", "TEXT"},
+        {"checksum_source", "TEXT"},
+        {"etag", "TEXT"},
+        {"last_modified", "TEXT"},
+        {"speed_limit", "INTEGER DEFAULT 0"},
+        {"weight", "INTEGER DEFAULT 1"},
+        {"queue_id", "INTEGER DEFAULT 1"},
+        {"queue_position", "INTEGER DEFAULT 0"},
+        {"priority", "INTEGER DEFAULT 0"},
+        {"package_id", "INTEGER DEFAULT 0"},
+    }); err != nil {
+        return err
+    }
+
+    // Once the column exists in databases from before packages
+    if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_downloads_package ON downloads(package_id)"); err != nil {
+        return err
+    }
+
+    return addMissingColumns(tx, "chunks", [][2]string{
+        {"attempts", "INTEGER DEFAULT 0"},
+        {"last_error", "TEXT"},
+    })
+}
+
+// addMissingColumns adds columns introduced after a table was first created,
+// since CREATE TABLE IF NOT EXISTS leaves existing tables untouched
+func addMissingColumns(tx *sql.Tx, table string, columns [][2]string) error {
+    rows, err := tx.Query("PRAGMA table_info(" + table + ")")
+    if err != nil {
+        return err
+    }
+
+    existing := make(map[string]bool)
+    for rows.Next() {
+        var cid, notNull, pk int
+        var name, typ string
+        var defaultValue sql.NullString
+        if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultValue, &pk); err != nil {
+            rows.Close()
+            return err
+        }
+        existing[name] = true
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for _, column := range columns {
+        if existing[column[0]] {
+            continue
+        }
+        if _, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column[0] + " " + column[1]); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// removeOrphans deletes rows left behind by downloads deleted one statement
+// at a time, before deleting them became a single transaction
+func removeOrphans(tx *sql.Tx) error {
+    queries := []string{
+        "DELETE FROM chunks WHERE download_id NOT IN (SELECT id FROM downloads)",
+        "DELETE FROM dependencies WHERE download_id NOT IN (SELECT id FROM downloads) OR depends_on NOT IN (SELECT id FROM downloads)",
+    }
+    for _, query := range queries {
+        if _, err := tx.Exec(query); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
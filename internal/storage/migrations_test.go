@@ -0,0 +1,148 @@
+package storage
+
+import (
+    "database/sql"
+    "fmt"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// legacySchema is a database from before schema versions: user_version 0,
+// fewer columns, and a chunk left behind by a deleted download
+const legacySchema = `
+CREATE TABLE downloads (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    filename TEXT NOT NULL,
+    path TEXT NOT NULL,
+    size INTEGER DEFAULT 0,
+    downloaded INTEGER DEFAULT 0,
+    status INTEGER DEFAULT 0,
+    speed INTEGER DEFAULT 0,
+    progress REAL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    started_at DATETIME,
+    completed_at DATETIME,
+    error TEXT,
+    chunks INTEGER DEFAULT 1
+);
+CREATE TABLE chunks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    download_id INTEGER NOT NULL,
+    start_offset INTEGER NOT NULL,
+    end_offset INTEGER NOT NULL,
+    downloaded INTEGER DEFAULT 0
+);
+INSERT INTO downloads (url, filename, path) VALUES ('http://example.com/a.bin', 'a.bin', '/tmp');
+INSERT INTO chunks (download_id, start_offset, end_offset) VALUES (1, 0, 99), (42, 0, 99);`
+
+// requireSQLite skips tests that need a working SQLite driver, which the
+// cgo one is not when built with CGO_ENABLED=0
+func requireSQLite(t *testing.T) {
+    t.Helper()
+
+    db, err := sql.Open(driverName, ":memory:")
+    if err == nil {
+        err = db.Ping()
+        db.Close()
+    }
+    if err != nil {
+        t.Skipf("SQLite is not available in this build: %v", err)
+    }
+}
+
+func openDatabase(t *testing.T, path string) *sql.DB {
+    t.Helper()
+
+    db, err := sql.Open(driverName, path+connectionOptions)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+    return db
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+    t.Helper()
+
+    var count int
+    if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count); err != nil {
+        t.Fatal(err)
+    }
+    return count
+}
+
+// TestMigrateBacksUpUntouchedDatabase checks that the backup taken before
+// a destructive migration is the database as it was, not a half migrated one
+func TestMigrateBacksUpUntouchedDatabase(t *testing.T) {
+    requireSQLite(t)
+    dir := t.TempDir()
+    path := filepath.Join(dir, "idm.db")
+
+    legacy := openDatabase(t, path)
+    if _, err := legacy.Exec(legacySchema); err != nil {
+        t.Fatal(err)
+    }
+    legacy.Close()
+
+    repository, err := OpenSQLite(path, filepath.Join(dir, "settings.json"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer repository.Close()
+
+    if version, _ := schemaVersion(repository.db); version != SchemaVersion() {
+        t.Errorf("migrated to version %d, want %d", version, SchemaVersion())
+    }
+    if count := countRows(t, repository.db, "chunks"); count != 1 {
+        t.Errorf("%d chunks after the migration, want the orphan removed", count)
+    }
+    var index int
+    repository.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_downloads_package'").Scan(&index)
+    if index != 1 {
+        t.Error("no index on the package of downloads after the migration")
+    }
+
+    backupPath := path + ".v0.bak"
+    if _, err := os.Stat(backupPath); err != nil {
+        t.Fatalf("no backup of the version 0 database: %v", err)
+    }
+    for version := 1; version <= SchemaVersion(); version++ {
+        if _, err := os.Stat(fmt.Sprintf("%s.v%d.bak", path, version)); err == nil {
+            t.Errorf("unexpected backup of version %d", version)
+        }
+    }
+
+    backup := openDatabase(t, backupPath)
+    if version, _ := schemaVersion(backup); version != 0 {
+        t.Errorf("the backup holds schema version %d, want 0", version)
+    }
+    if count := countRows(t, backup, "chunks"); count != 2 {
+        t.Errorf("the backup holds %d chunks, want the 2 from before the migration", count)
+    }
+    if queues, _ := hasTable(backup, "queues"); queues {
+        t.Error("the backup has tables from the migrations")
+    }
+}
+
+// TestMigrateNewDatabase checks that a new database is created at the
+// current version without a backup
+func TestMigrateNewDatabase(t *testing.T) {
+    requireSQLite(t)
+    dir := t.TempDir()
+    path := filepath.Join(dir, "idm.db")
+
+    repository, err := OpenSQLite(path, filepath.Join(dir, "settings.json"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer repository.Close()
+
+    if version, _ := schemaVersion(repository.db); version != SchemaVersion() {
+        t.Errorf("new database at version %d, want %d", version, SchemaVersion())
+    }
+    if backups, _ := filepath.Glob(filepath.Join(dir, "*.bak")); len(backups) != 0 {
+        t.Errorf("a new database was backed up: %v", backups)
+    }
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+    "database/sql"
+    "time"
+)
+
+// Package groups downloads that belong together, such as the files of one
+// dataset
+type Package struct {
+    ID        int64     `json:"id"`
+    Name      string    `json:"name"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+func SavePackage(db *sql.DB, pkg *Package) (int64, error) {
+    query := "INSERT INTO packages (name, created_at) VALUES (?, ?)"
+
+    result, err := db.Exec(query, pkg.Name, pkg.CreatedAt)
+    if err != nil {
+        return 0, err
+    }
+
+    return result.LastInsertId()
+}
+
+func GetPackage(db *sql.DB, id int64) (*Package, error) {
+    query := "SELECT id, name, created_at FROM packages WHERE id = ?"
+
+    pkg := &Package{}
+    if err := db.QueryRow(query, id).Scan(&pkg.ID, &pkg.Name, &pkg.CreatedAt); err != nil {
+        return nil, err
+    }
+
+    return pkg, nil
+}
+
+func GetPackages(db *sql.DB) ([]*Package, error) {
+    query := "SELECT id, name, created_at FROM packages ORDER BY created_at DESC"
+
+    rows, err := db.Query(query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var packages []*Package
+
+    for rows.Next() {
+        pkg := &Package{}
+        if err := rows.Scan(&pkg.ID, &pkg.Name, &pkg.CreatedAt); err != nil {
+            return nil, err
+        }
+        packages = append(packages, pkg)
+    }
+
+    return packages, rows.Err()
+}
+
+// GetPackageDownloads returns the downloads of a package in the order they
+// were added
+func GetPackageDownloads(db *sql.DB, packageID int64) ([]*Download, error) {
+    return queryDownloads(db, "WHERE package_id = ? ORDER BY created_at, id", packageID)
+}
+
+// DeletePackage removes a package. Its downloads stay, on their own.
+func DeletePackage(db *sql.DB, id int64) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec("UPDATE downloads SET package_id = 0 WHERE package_id = ?", id); err != nil {
+        return err
+    }
+    if _, err := tx.Exec("DELETE FROM packages WHERE id = ?", id); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
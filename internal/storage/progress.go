@@ -0,0 +1,133 @@
+package storage
+
+import (
+    "context"
+    "database/sql"
+    "sync"
+    "time"
+)
+
+// Progress is the part of a download that changes while it runs
+type Progress struct {
+    DownloadID int64
+    Downloaded int64
+    Progress   float64
+    Speed      int64
+    Chunks     []*Chunk // nil leaves the stored chunks alone
+}
+
+// ProgressWriter holds back the progress of running downloads and writes
+// it in one transaction per interval, keeping only the latest progress of
+// each download. Status changes must not wait for it: write them directly,
+// after calling Forget so that older progress cannot follow them.
+type ProgressWriter struct {
+    store      Repository
+    interval   time.Duration
+    mutex      sync.Mutex // guards pending
+    flushMutex sync.Mutex // held while a batch is written
+    pending    map[int64]*Progress
+}
+
+func NewProgressWriter(store Repository, interval time.Duration) *ProgressWriter {
+    return &ProgressWriter{
+        store:    store,
+        interval: interval,
+        pending:  make(map[int64]*Progress),
+    }
+}
+
+// Record queues the progress of a download, replacing any not yet written
+func (w *ProgressWriter) Record(progress *Progress) {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    if previous, ok := w.pending[progress.DownloadID]; ok && progress.Chunks == nil {
+        progress.Chunks = previous.Chunks
+    }
+    w.pending[progress.DownloadID] = progress
+}
+
+// Forget drops the progress of a download not written yet. A batch being
+// written is waited for.
+func (w *ProgressWriter) Forget(downloadID int64) {
+    w.flushMutex.Lock()
+    defer w.flushMutex.Unlock()
+
+    w.mutex.Lock()
+    delete(w.pending, downloadID)
+    w.mutex.Unlock()
+}
+
+// Flush writes all queued progress in one transaction
+func (w *ProgressWriter) Flush() error {
+    w.flushMutex.Lock()
+    defer w.flushMutex.Unlock()
+
+    w.mutex.Lock()
+    batch := w.pending
+    w.pending = make(map[int64]*Progress)
+    w.mutex.Unlock()
+
+    if len(batch) == 0 {
+        return nil
+    }
+
+    progress := make([]*Progress, 0, len(batch))
+    for _, p := range batch {
+        progress = append(progress, p)
+    }
+
+    if err := w.store.SaveProgress(progress); err != nil {
+        // Keep what was not written, unless newer progress came in
+        w.mutex.Lock()
+        for id, progress := range batch {
+            if _, ok := w.pending[id]; !ok {
+                w.pending[id] = progress
+            }
+        }
+        w.mutex.Unlock()
+        return err
+    }
+
+    return nil
+}
+
+// SaveProgress writes the progress of several downloads in one transaction
+func SaveProgress(db *sql.DB, batch []*Progress) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    query := "UPDATE downloads SET downloaded = ?, progress = ?, speed = ? WHERE id = ?"
+
+    for _, progress := range batch {
+        if _, err := tx.Exec(query, progress.Downloaded, progress.Progress, progress.Speed, progress.DownloadID); err != nil {
+            return err
+        }
+        if progress.Chunks != nil {
+            if err := replaceChunks(tx, progress.DownloadID, progress.Chunks); err != nil {
+                return err
+            }
+        }
+    }
+
+    return tx.Commit()
+}
+
+// Run flushes every interval until ctx is cancelled, then one last time
+func (w *ProgressWriter) Run(ctx context.Context) {
+    ticker := time.NewTicker(w.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            w.Flush()
+            return
+        case <-ticker.C:
+            w.Flush()
+        }
+    }
+}
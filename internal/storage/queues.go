@@ -0,0 +1,131 @@
+package storage
+
+import (
+    "database/sql"
+    "fmt"
+)
+
+// MainQueueID is the queue every download belongs to unless moved elsewhere
+const MainQueueID int64 = 1
+
+// QueueInfo holds the settings of a named download queue
+type QueueInfo struct {
+    ID            int64  `json:"id"`
+    Name          string `json:"name"`
+    MaxConcurrent int    `json:"max_concurrent"` // downloads running at once
+    MaxSpeed      int64  `json:"max_speed"`      // bytes per second, 0 for unlimited
+    Running       bool   `json:"running"`        // stopped queues start nothing
+}
+
+// SaveQueue inserts a new queue, or updates it when it has an ID
+func SaveQueue(db *sql.DB, queue *QueueInfo) (int64, error) {
+    if queue.ID != 0 {
+        query := `
+        UPDATE queues SET name = ?, max_concurrent = ?, max_speed = ?, running = ?
+        WHERE id = ?`
+
+        _, err := db.Exec(query, queue.Name, queue.MaxConcurrent, queue.MaxSpeed, queue.Running, queue.ID)
+        return queue.ID, err
+    }
+
+    query := `
+    INSERT INTO queues (name, max_concurrent, max_speed, running)
+    VALUES (?, ?, ?, ?)`
+
+    result, err := db.Exec(query, queue.Name, queue.MaxConcurrent, queue.MaxSpeed, queue.Running)
+    if err != nil {
+        return 0, err
+    }
+
+    return result.LastInsertId()
+}
+
+func GetQueues(db *sql.DB) ([]*QueueInfo, error) {
+    query := `
+    SELECT id, name, max_concurrent, max_speed, running
+    FROM queues ORDER BY id`
+
+    rows, err := db.Query(query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var queues []*QueueInfo
+
+    for rows.Next() {
+        queue := &QueueInfo{}
+
+        err := rows.Scan(
+            &queue.ID,
+            &queue.Name,
+            &queue.MaxConcurrent,
+            &queue.MaxSpeed,
+            &queue.Running,
+        )
+
+        if err != nil {
+            return nil, err
+        }
+
+        queues = append(queues, queue)
+    }
+
+    return queues, rows.Err()
+}
+
+// DeleteQueue removes a queue and its schedules. Its downloads move to the
+// main queue, which itself cannot be deleted.
+func DeleteQueue(db *sql.DB, id int64) error {
+    if id == MainQueueID {
+        return fmt.Errorf("the main queue cannot be deleted")
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec("UPDATE downloads SET queue_id = ? WHERE queue_id = ?", MainQueueID, id); err != nil {
+        return err
+    }
+    if _, err := tx.Exec("DELETE FROM queue_schedules WHERE queue_id = ?", id); err != nil {
+        return err
+    }
+    if _, err := tx.Exec("DELETE FROM queues WHERE id = ?", id); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// SetDownloadQueue moves a download to another queue
+func SetDownloadQueue(db *sql.DB, downloadID, queueID int64) error {
+    query := "UPDATE downloads SET queue_id = ? WHERE id = ?"
+    _, err := db.Exec(query, queueID, downloadID)
+    return err
+}
+
+// SaveQueueOrder stores the order of the waiting downloads of a queue
+func SaveQueueOrder(db *sql.DB, downloadIDs []int64) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    for position, id := range downloadIDs {
+        if _, err := tx.Exec("UPDATE downloads SET queue_position = ? WHERE id = ?", position, id); err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit()
+}
+
+func SetDownloadPriority(db *sql.DB, downloadID int64, priority Priority) error {
+    query := "UPDATE downloads SET priority = ? WHERE id = ?"
+    _, err := db.Exec(query, int(priority), downloadID)
+    return err
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+)
+
+// ErrNotFound is returned when a download or package does not exist
+var ErrNotFound = errors.New("not found")
+
+// Repository is everything the download manager keeps between runs
+type Repository interface {
+    SaveDownload(download *Download) (int64, error)
+    UpdateDownload(download *Download) error
+    GetDownload(id int64) (*Download, error)
+    GetAllDownloads() ([]*Download, error)
+    DeleteDownload(id int64) error
+    SaveProgress(batch []*Progress) error
+
+    SaveChunks(downloadID int64, chunks []*Chunk) error
+    GetChunks(downloadID int64) ([]*Chunk, error)
+    DeleteChunks(downloadID int64) error
+
+    AddDependency(downloadID, dependsOn int64) error
+    RemoveDependency(downloadID, dependsOn int64) error
+    GetDependencies(downloadID int64) ([]int64, error)
+    GetDependents(downloadID int64) ([]int64, error)
+
+    SaveQueue(queue *QueueInfo) (int64, error)
+    GetQueues() ([]*QueueInfo, error)
+    DeleteQueue(id int64) error
+    SetDownloadQueue(downloadID, queueID int64) error
+    SaveQueueOrder(downloadIDs []int64) error
+    SetDownloadPriority(downloadID int64, priority Priority) error
+
+    SaveQueueSchedule(schedule *QueueSchedule) (int64, error)
+    GetQueueSchedules() ([]*QueueSchedule, error)
+    DeleteQueueSchedule(id int64) error
+
+    SavePackage(pkg *Package) (int64, error)
+    GetPackage(id int64) (*Package, error)
+    GetPackages() ([]*Package, error)
+    GetPackageDownloads(packageID int64) ([]*Download, error)
+    DeletePackage(id int64) error
+
+    // Saving settings leaves the keys not given alone
+    GetSettings() (map[string]string, error)
+    SaveSettings(settings map[string]string) error
+
+    Close() error
+}
+
+// SQLiteRepository keeps everything in a SQLite database, through the
+// functions of this package, except the settings: those are in a
+// SettingsFile in the configuration directory
+type SQLiteRepository struct {
+    db       *sql.DB
+    settings *SettingsFile
+}
+
+var _ Repository = (*SQLiteRepository)(nil)
+
+// OpenSQLite opens the database at path, creating it if needed, and brings
+// its schema up to date. The settings are kept at settingsPath.
+func OpenSQLite(path, settingsPath string) (*SQLiteRepository, error) {
+    db, err := sql.Open(driverName, path+connectionOptions)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := migrate(db, path); err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    return &SQLiteRepository{db: db, settings: NewSettingsFile(settingsPath)}, nil
+}
+
+func (r *SQLiteRepository) Close() error {
+    return r.db.Close()
+}
+
+func (r *SQLiteRepository) SaveDownload(download *Download) (int64, error) {
+    return SaveDownload(r.db, download)
+}
+
+func (r *SQLiteRepository) UpdateDownload(download *Download) error {
+    return UpdateDownload(r.db, download)
+}
+
+func (r *SQLiteRepository) GetDownload(id int64) (*Download, error) {
+    download, err := GetDownload(r.db, id)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, fmt.Errorf("download %d: %w", id, ErrNotFound)
+    }
+    return download, err
+}
+
+func (r *SQLiteRepository) GetAllDownloads() ([]*Download, error) {
+    return GetAllDownloads(r.db)
+}
+
+func (r *SQLiteRepository) DeleteDownload(id int64) error {
+    return DeleteDownload(r.db, id)
+}
+
+func (r *SQLiteRepository) SaveProgress(batch []*Progress) error {
+    return SaveProgress(r.db, batch)
+}
+
+func (r *SQLiteRepository) SaveChunks(downloadID int64, chunks []*Chunk) error {
+    return SaveChunks(r.db, downloadID, chunks)
+}
+
+func (r *SQLiteRepository) GetChunks(downloadID int64) ([]*Chunk, error) {
+    return GetChunks(r.db, downloadID)
+}
+
+func (r *SQLiteRepository) DeleteChunks(downloadID int64) error {
+    return DeleteChunks(r.db, downloadID)
+}
+
+func (r *SQLiteRepository) AddDependency(downloadID, dependsOn int64) error {
+    return AddDependency(r.db, downloadID, dependsOn)
+}
+
+func (r *SQLiteRepository) RemoveDependency(downloadID, dependsOn int64) error {
+    return RemoveDependency(r.db, downloadID, dependsOn)
+}
+
+func (r *SQLiteRepository) GetDependencies(downloadID int64) ([]int64, error) {
+    return GetDependencies(r.db, downloadID)
+}
+
+func (r *SQLiteRepository) GetDependents(downloadID int64) ([]int64, error) {
+    return GetDependents(r.db, downloadID)
+}
+
+func (r *SQLiteRepository) SaveQueue(queue *QueueInfo) (int64, error) {
+    return SaveQueue(r.db, queue)
+}
+
+func (r *SQLiteRepository) GetQueues() ([]*QueueInfo, error) {
+    return GetQueues(r.db)
+}
+
+func (r *SQLiteRepository) DeleteQueue(id int64) error {
+    return DeleteQueue(r.db, id)
+}
+
+func (r *SQLiteRepository) SetDownloadQueue(downloadID, queueID int64) error {
+    return SetDownloadQueue(r.db, downloadID, queueID)
+}
+
+func (r *SQLiteRepository) SaveQueueOrder(downloadIDs []int64) error {
+    return SaveQueueOrder(r.db, downloadIDs)
+}
+
+func (r *SQLiteRepository) SetDownloadPriority(downloadID int64, priority Priority) error {
+    return SetDownloadPriority(r.db, downloadID, priority)
+}
+
+func (r *SQLiteRepository) SaveQueueSchedule(schedule *QueueSchedule) (int64, error) {
+    return SaveQueueSchedule(r.db, schedule)
+}
+
+func (r *SQLiteRepository) GetQueueSchedules() ([]*QueueSchedule, error) {
+    return GetQueueSchedules(r.db)
+}
+
+func (r *SQLiteRepository) DeleteQueueSchedule(id int64) error {
+    return DeleteQueueSchedule(r.db, id)
+}
+
+func (r *SQLiteRepository) SavePackage(pkg *Package) (int64, error) {
+    return SavePackage(r.db, pkg)
+}
+
+func (r *SQLiteRepository) GetPackage(id int64) (*Package, error) {
+    pkg, err := GetPackage(r.db, id)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, fmt.Errorf("package %d: %w", id, ErrNotFound)
+    }
+    return pkg, err
+}
+
+func (r *SQLiteRepository) GetPackages() ([]*Package, error) {
+    return GetPackages(r.db)
+}
+
+func (r *SQLiteRepository) GetPackageDownloads(packageID int64) ([]*Download, error) {
+    return GetPackageDownloads(r.db, packageID)
+}
+
+func (r *SQLiteRepository) DeletePackage(id int64) error {
+    return DeletePackage(r.db, id)
+}
+
+func (r *SQLiteRepository) GetSettings() (map[string]string, error) {
+    return r.settings.GetSettings()
+}
+
+func (r *SQLiteRepository) SaveSettings(settings map[string]string) error {
+    return r.settings.SaveSettings(settings)
+}
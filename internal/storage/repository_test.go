@@ -0,0 +1,486 @@
+package storage
+
+import (
+    "errors"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// TestRepositories runs the same contract against every implementation, so
+// the in-memory repository cannot drift from the SQLite one
+func TestRepositories(t *testing.T) {
+    implementations := []struct {
+        name string
+        open func(t *testing.T) Repository
+    }{
+        {"sqlite", func(t *testing.T) Repository {
+            requireSQLite(t)
+            dir := t.TempDir()
+            repository, err := OpenSQLite(filepath.Join(dir, "idm.db"), filepath.Join(dir, "settings.json"))
+            if err != nil {
+                t.Fatal(err)
+            }
+            t.Cleanup(func() { repository.Close() })
+            return repository
+        }},
+        {"memory", func(t *testing.T) Repository {
+            return NewMemoryRepository()
+        }},
+    }
+
+    contract := []struct {
+        name string
+        test func(t *testing.T, r Repository)
+    }{
+        {"downloads", testDownloads},
+        {"progress", testProgress},
+        {"chunks", testChunks},
+        {"dependencies", testDependencies},
+        {"queues", testQueues},
+        {"queue schedules", testQueueSchedules},
+        {"packages", testPackages},
+        {"settings", testSettings},
+    }
+
+    for _, implementation := range implementations {
+        for _, c := range contract {
+            implementation, c := implementation, c
+            t.Run(implementation.name+"/"+c.name, func(t *testing.T) {
+                c.test(t, implementation.open(t))
+            })
+        }
+    }
+}
+
+// created is a fixed time without monotonic reading or zone, which both
+// implementations return unchanged
+var created = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newDownload(name string, createdAt time.Time) *Download {
+    return &Download{
+        URL:       "http://example.com/" + name,
+        Filename:  name,
+        Path:      "/tmp",
+        Size:      1000,
+        Status:    StatusPending,
+        CreatedAt: createdAt,
+        Chunks:    4,
+        Weight:    DefaultWeight,
+        QueueID:   MainQueueID,
+    }
+}
+
+func saveDownload(t *testing.T, r Repository, download *Download) int64 {
+    t.Helper()
+
+    id, err := r.SaveDownload(download)
+    if err != nil {
+        t.Fatal(err)
+    }
+    download.ID = id
+    return id
+}
+
+func getDownload(t *testing.T, r Repository, id int64) *Download {
+    t.Helper()
+
+    download, err := r.GetDownload(id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return download
+}
+
+func testDownloads(t *testing.T, r Repository) {
+    download := newDownload("a.bin", created)
+    download.ChecksumAlgorithm = "sha256"
+    download.Checksum = "abc"
+    download.ChecksumSource = ChecksumFromUser
+    download.ETag = `"v1"`
+    download.SpeedLimit = 500
+    id := saveDownload(t, r, download)
+
+    got := getDownload(t, r, id)
+    if got.URL != download.URL || got.Filename != download.Filename || got.Path != download.Path ||
+        got.Size != download.Size || got.Status != download.Status || !got.CreatedAt.Equal(created) ||
+        got.Chunks != download.Chunks || got.Checksum != download.Checksum || got.ETag != download.ETag ||
+        got.SpeedLimit != download.SpeedLimit || got.Weight != download.Weight || got.QueueID != MainQueueID {
+        t.Errorf("GetDownload = %+v, saved %+v", got, download)
+    }
+
+    // UpdateDownload keeps the queue and package fields, they have their own setters
+    completedAt := created.Add(time.Hour)
+    got.Status = StatusCompleted
+    got.Downloaded = 1000
+    got.Progress = 100
+    got.CompletedAt = &completedAt
+    got.Error = "none"
+    got.QueueID = 99
+    got.PackageID = 99
+    got.URL = "http://example.com/other"
+    if err := r.UpdateDownload(got); err != nil {
+        t.Fatal(err)
+    }
+    updated := getDownload(t, r, id)
+    if updated.Status != StatusCompleted || updated.Downloaded != 1000 || updated.Progress != 100 ||
+        updated.CompletedAt == nil || !updated.CompletedAt.Equal(completedAt) || updated.Error != "none" {
+        t.Errorf("UpdateDownload did not store the state: %+v", updated)
+    }
+    if updated.QueueID != MainQueueID || updated.PackageID != 0 || updated.URL != download.URL {
+        t.Errorf("UpdateDownload changed the queue, package or URL: %+v", updated)
+    }
+
+    newer := saveDownload(t, r, newDownload("b.bin", created.Add(time.Minute)))
+    all, err := r.GetAllDownloads()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if ids := downloadIDs(all); !reflect.DeepEqual(ids, []int64{newer, id}) {
+        t.Errorf("GetAllDownloads = %v, want the newest first %v", ids, []int64{newer, id})
+    }
+
+    if err := r.DeleteDownload(id); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := r.GetDownload(id); !errors.Is(err, ErrNotFound) {
+        t.Errorf("GetDownload after DeleteDownload: %v, want ErrNotFound", err)
+    }
+}
+
+func testProgress(t *testing.T, r Repository) {
+    id := saveDownload(t, r, newDownload("a.bin", created))
+    chunks := []*Chunk{{Start: 0, End: 499, Downloaded: 100}, {Start: 500, End: 999, Downloaded: 50}}
+    if err := r.SaveChunks(id, chunks); err != nil {
+        t.Fatal(err)
+    }
+
+    // Progress for a download deleted meanwhile is dropped
+    batch := []*Progress{
+        {DownloadID: id, Downloaded: 300, Progress: 30, Speed: 10},
+        {DownloadID: id + 1000, Downloaded: 1},
+    }
+    if err := r.SaveProgress(batch); err != nil {
+        t.Fatal(err)
+    }
+    got := getDownload(t, r, id)
+    if got.Downloaded != 300 || got.Progress != 30 {
+        t.Errorf("after SaveProgress downloaded %d progress %v, want 300 and 30", got.Downloaded, got.Progress)
+    }
+    // nil chunks leave the stored ones alone
+    if stored, _ := r.GetChunks(id); len(stored) != 2 || stored[0].Downloaded != 100 {
+        t.Errorf("SaveProgress without chunks changed them: %v", chunkRanges(stored))
+    }
+
+    batch = []*Progress{{DownloadID: id, Downloaded: 400, Progress: 40, Chunks: []*Chunk{
+        {Start: 0, End: 499, Downloaded: 250}, {Start: 500, End: 999, Downloaded: 150},
+    }}}
+    if err := r.SaveProgress(batch); err != nil {
+        t.Fatal(err)
+    }
+    if stored, _ := r.GetChunks(id); len(stored) != 2 || stored[0].Downloaded != 250 || stored[1].Downloaded != 150 {
+        t.Errorf("SaveProgress with chunks did not store them: %v", chunkRanges(stored))
+    }
+}
+
+func testChunks(t *testing.T, r Repository) {
+    id := saveDownload(t, r, newDownload("a.bin", created))
+
+    // Stored by offset, whatever order they come in
+    chunks := []*Chunk{
+        {Start: 500, End: 999, Downloaded: 20, Attempts: 2, LastError: "reset"},
+        {Start: 0, End: 499, Downloaded: 10},
+    }
+    if err := r.SaveChunks(id, chunks); err != nil {
+        t.Fatal(err)
+    }
+    stored, err := r.GetChunks(id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := chunkRanges(stored); !reflect.DeepEqual(got, [][3]int64{{0, 499, 10}, {500, 999, 20}}) {
+        t.Errorf("GetChunks = %v", got)
+    }
+    if stored[1].Attempts != 2 || stored[1].LastError != "reset" || stored[1].DownloadID != id {
+        t.Errorf("GetChunks lost the chunk details: %+v", stored[1])
+    }
+
+    // Saving replaces the whole layout
+    if err := r.SaveChunks(id, []*Chunk{{Start: 0, End: 999, Downloaded: 30}}); err != nil {
+        t.Fatal(err)
+    }
+    if stored, _ := r.GetChunks(id); !reflect.DeepEqual(chunkRanges(stored), [][3]int64{{0, 999, 30}}) {
+        t.Errorf("GetChunks after replacing = %v", chunkRanges(stored))
+    }
+
+    if err := r.DeleteChunks(id); err != nil {
+        t.Fatal(err)
+    }
+    if stored, _ := r.GetChunks(id); len(stored) != 0 {
+        t.Errorf("GetChunks after DeleteChunks = %v", chunkRanges(stored))
+    }
+
+    // Deleting the download takes its chunks along
+    if err := r.SaveChunks(id, chunks); err != nil {
+        t.Fatal(err)
+    }
+    if err := r.DeleteDownload(id); err != nil {
+        t.Fatal(err)
+    }
+    if stored, _ := r.GetChunks(id); len(stored) != 0 {
+        t.Errorf("GetChunks after DeleteDownload = %v", chunkRanges(stored))
+    }
+}
+
+func testDependencies(t *testing.T, r Repository) {
+    a := saveDownload(t, r, newDownload("a.bin", created))
+    b := saveDownload(t, r, newDownload("b.bin", created))
+    c := saveDownload(t, r, newDownload("c.bin", created))
+
+    for _, link := range [][2]int64{{c, a}, {c, b}, {b, a}} {
+        if err := r.AddDependency(link[0], link[1]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Adding a link twice is harmless
+    if err := r.AddDependency(c, a); err != nil {
+        t.Fatal(err)
+    }
+
+    if got, _ := r.GetDependencies(c); !reflect.DeepEqual(got, []int64{a, b}) {
+        t.Errorf("GetDependencies(c) = %v, want %v", got, []int64{a, b})
+    }
+    if got, _ := r.GetDependents(a); !reflect.DeepEqual(got, []int64{b, c}) {
+        t.Errorf("GetDependents(a) = %v, want %v", got, []int64{b, c})
+    }
+
+    if err := r.RemoveDependency(c, b); err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := r.GetDependencies(c); !reflect.DeepEqual(got, []int64{a}) {
+        t.Errorf("GetDependencies(c) after RemoveDependency = %v, want %v", got, []int64{a})
+    }
+
+    // Deleting a download removes the links in both directions
+    if err := r.DeleteDownload(a); err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := r.GetDependencies(c); len(got) != 0 {
+        t.Errorf("GetDependencies(c) after deleting a = %v", got)
+    }
+    if got, _ := r.GetDependencies(b); len(got) != 0 {
+        t.Errorf("GetDependencies(b) after deleting a = %v", got)
+    }
+    if got, _ := r.GetDependents(a); len(got) != 0 {
+        t.Errorf("GetDependents(a) after deleting it = %v", got)
+    }
+}
+
+func testQueues(t *testing.T, r Repository) {
+    queues, err := r.GetQueues()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(queues) != 1 || queues[0].ID != MainQueueID {
+        t.Fatalf("a new repository has queues %+v, want only the main queue", queues)
+    }
+
+    night := &QueueInfo{Name: "Night", MaxConcurrent: 2, MaxSpeed: 100}
+    id, err := r.SaveQueue(night)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := r.SaveQueue(&QueueInfo{Name: "Night", MaxConcurrent: 1}); err == nil {
+        t.Error("SaveQueue accepted a second queue with the same name")
+    }
+
+    night.ID = id
+    night.Running = true
+    night.MaxSpeed = 200
+    if _, err := r.SaveQueue(night); err != nil {
+        t.Fatal(err)
+    }
+    queues, _ = r.GetQueues()
+    if len(queues) != 2 || !reflect.DeepEqual(*queues[1], *night) {
+        t.Errorf("GetQueues = %+v, want the main queue and %+v", queues, night)
+    }
+
+    a := saveDownload(t, r, newDownload("a.bin", created))
+    b := saveDownload(t, r, newDownload("b.bin", created))
+    if err := r.SetDownloadQueue(a, id); err != nil {
+        t.Fatal(err)
+    }
+    if err := r.SaveQueueOrder([]int64{b, a}); err != nil {
+        t.Fatal(err)
+    }
+    if err := r.SetDownloadPriority(a, PriorityHigh); err != nil {
+        t.Fatal(err)
+    }
+    if got := getDownload(t, r, a); got.QueueID != id || got.QueuePosition != 1 || got.Priority != PriorityHigh {
+        t.Errorf("download a has queue %d position %d priority %v, want %d, 1 and high", got.QueueID, got.QueuePosition, got.Priority, id)
+    }
+    if got := getDownload(t, r, b); got.QueuePosition != 0 {
+        t.Errorf("download b has position %d, want 0", got.QueuePosition)
+    }
+
+    schedule := &QueueSchedule{QueueID: id, Days: 1, StartTime: 60, StopTime: 120, Enabled: true}
+    if _, err := r.SaveQueueSchedule(schedule); err != nil {
+        t.Fatal(err)
+    }
+
+    // Deleting a queue moves its downloads to the main queue and drops its schedules
+    if err := r.DeleteQueue(MainQueueID); err == nil {
+        t.Error("DeleteQueue deleted the main queue")
+    }
+    if err := r.DeleteQueue(id); err != nil {
+        t.Fatal(err)
+    }
+    if queues, _ := r.GetQueues(); len(queues) != 1 {
+        t.Errorf("GetQueues after DeleteQueue = %+v", queues)
+    }
+    if got := getDownload(t, r, a); got.QueueID != MainQueueID {
+        t.Errorf("download of a deleted queue is in queue %d, want the main queue", got.QueueID)
+    }
+    if schedules, _ := r.GetQueueSchedules(); len(schedules) != 0 {
+        t.Errorf("schedules of a deleted queue remain: %+v", schedules)
+    }
+}
+
+func testQueueSchedules(t *testing.T, r Repository) {
+    id, err := r.SaveQueue(&QueueInfo{Name: "Night", MaxConcurrent: 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    late := &QueueSchedule{QueueID: id, Days: 0x3e, StartTime: 22 * 60, StopTime: 6 * 60, Enabled: true}
+    early := &QueueSchedule{QueueID: id, Days: 0x41, StartTime: 60, StopTime: 120}
+    main := &QueueSchedule{QueueID: MainQueueID, Days: 0x7f, StartTime: 23 * 60, StopTime: 0, Enabled: true}
+    for _, schedule := range []*QueueSchedule{late, early, main} {
+        if schedule.ID, err = r.SaveQueueSchedule(schedule); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // By queue, then by start time
+    schedules, err := r.GetQueueSchedules()
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []QueueSchedule{*main, *early, *late}
+    if len(schedules) != len(want) {
+        t.Fatalf("GetQueueSchedules = %+v, want %+v", schedules, want)
+    }
+    for i := range want {
+        if *schedules[i] != want[i] {
+            t.Errorf("GetQueueSchedules()[%d] = %+v, want %+v", i, *schedules[i], want[i])
+        }
+    }
+
+    early.Enabled = true
+    early.StopTime = 180
+    if _, err := r.SaveQueueSchedule(early); err != nil {
+        t.Fatal(err)
+    }
+    if err := r.DeleteQueueSchedule(late.ID); err != nil {
+        t.Fatal(err)
+    }
+    schedules, _ = r.GetQueueSchedules()
+    if len(schedules) != 2 || *schedules[1] != *early {
+        t.Errorf("GetQueueSchedules after update and delete = %+v", schedules)
+    }
+}
+
+func testPackages(t *testing.T, r Repository) {
+    older, err := r.SavePackage(&Package{Name: "older", CreatedAt: created})
+    if err != nil {
+        t.Fatal(err)
+    }
+    id, err := r.SavePackage(&Package{Name: "release", CreatedAt: created.Add(time.Hour)})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    pkg, err := r.GetPackage(id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if pkg.Name != "release" || !pkg.CreatedAt.Equal(created.Add(time.Hour)) {
+        t.Errorf("GetPackage = %+v", pkg)
+    }
+    if _, err := r.GetPackage(id + 1000); !errors.Is(err, ErrNotFound) {
+        t.Errorf("GetPackage of a missing package: %v, want ErrNotFound", err)
+    }
+    packages, _ := r.GetPackages()
+    if len(packages) != 2 || packages[0].ID != id || packages[1].ID != older {
+        t.Errorf("GetPackages = %+v, want the newest first", packages)
+    }
+
+    var members []int64
+    for i, name := range []string{"a.bin", "b.bin"} {
+        download := newDownload(name, created.Add(time.Duration(i)*time.Minute))
+        download.PackageID = id
+        members = append(members, saveDownload(t, r, download))
+    }
+    saveDownload(t, r, newDownload("other.bin", created))
+
+    downloads, err := r.GetPackageDownloads(id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := downloadIDs(downloads); !reflect.DeepEqual(got, members) {
+        t.Errorf("GetPackageDownloads = %v, want %v in the order they were added", got, members)
+    }
+
+    // The downloads stay, on their own
+    if err := r.DeletePackage(id); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := r.GetPackage(id); !errors.Is(err, ErrNotFound) {
+        t.Errorf("GetPackage after DeletePackage: %v, want ErrNotFound", err)
+    }
+    for _, member := range members {
+        if got := getDownload(t, r, member); got.PackageID != 0 {
+            t.Errorf("download %d still in deleted package %d", member, got.PackageID)
+        }
+    }
+}
+
+func testSettings(t *testing.T, r Repository) {
+    settings, err := r.GetSettings()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(settings) != 0 {
+        t.Errorf("GetSettings of a new repository = %v", settings)
+    }
+
+    if err := r.SaveSettings(map[string]string{"max_speed": "0", "user_agent": "a"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := r.SaveSettings(map[string]string{"user_agent": "b"}); err != nil {
+        t.Fatal(err)
+    }
+
+    settings, _ = r.GetSettings()
+    want := map[string]string{"max_speed": "0", "user_agent": "b"}
+    if !reflect.DeepEqual(settings, want) {
+        t.Errorf("GetSettings = %v, want %v with the other keys kept", settings, want)
+    }
+}
+
+func downloadIDs(downloads []*Download) []int64 {
+    var ids []int64
+    for _, download := range downloads {
+        ids = append(ids, download.ID)
+    }
+    return ids
+}
+
+func chunkRanges(chunks []*Chunk) [][3]int64 {
+    var ranges [][3]int64
+    for _, chunk := range chunks {
+        ranges = append(ranges, [3]int64{chunk.Start, chunk.End, chunk.Downloaded})
+    }
+    return ranges
+}
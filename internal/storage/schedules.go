@@ -0,0 +1,97 @@
+package storage
+
+import (
+    "database/sql"
+    "time"
+)
+
+// QueueSchedule starts a queue at one time of day and stops it at another
+// on the chosen weekdays. A stop time not after the start time falls on
+// the next day.
+type QueueSchedule struct {
+    ID        int64 `json:"id"`
+    QueueID   int64 `json:"queue_id"`
+    Days      int   `json:"days"`       // bit n set for time.Weekday(n)
+    StartTime int   `json:"start_time"` // minutes since midnight
+    StopTime  int   `json:"stop_time"`  // minutes since midnight
+    Enabled   bool  `json:"enabled"`
+}
+
+// HasDay reports whether the schedule runs on day
+func (s *QueueSchedule) HasDay(day time.Weekday) bool {
+    return s.Days&(1<<uint(day)) != 0
+}
+
+// SetDay adds or removes day from the schedule
+func (s *QueueSchedule) SetDay(day time.Weekday, on bool) {
+    if on {
+        s.Days |= 1 << uint(day)
+    } else {
+        s.Days &^= 1 << uint(day)
+    }
+}
+
+// SaveQueueSchedule inserts a new schedule, or updates it when it has an ID
+func SaveQueueSchedule(db *sql.DB, schedule *QueueSchedule) (int64, error) {
+    if schedule.ID != 0 {
+        query := `
+        UPDATE queue_schedules SET queue_id = ?, days = ?, start_time = ?, stop_time = ?, enabled = ?
+        WHERE id = ?`
+
+        _, err := db.Exec(query, schedule.QueueID, schedule.Days, schedule.StartTime, schedule.StopTime,
+            schedule.Enabled, schedule.ID)
+        return schedule.ID, err
+    }
+
+    query := `
+    INSERT INTO queue_schedules (queue_id, days, start_time, stop_time, enabled)
+    VALUES (?, ?, ?, ?, ?)`
+
+    result, err := db.Exec(query, schedule.QueueID, schedule.Days, schedule.StartTime, schedule.StopTime, schedule.Enabled)
+    if err != nil {
+        return 0, err
+    }
+
+    return result.LastInsertId()
+}
+
+func GetQueueSchedules(db *sql.DB) ([]*QueueSchedule, error) {
+    query := `
+    SELECT id, queue_id, days, start_time, stop_time, enabled
+    FROM queue_schedules ORDER BY queue_id, start_time`
+
+    rows, err := db.Query(query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var schedules []*QueueSchedule
+
+    for rows.Next() {
+        schedule := &QueueSchedule{}
+
+        err := rows.Scan(
+            &schedule.ID,
+            &schedule.QueueID,
+            &schedule.Days,
+            &schedule.StartTime,
+            &schedule.StopTime,
+            &schedule.Enabled,
+        )
+
+        if err != nil {
+            return nil, err
+        }
+
+        schedules = append(schedules, schedule)
+    }
+
+    return schedules, rows.Err()
+}
+
+func DeleteQueueSchedule(db *sql.DB, id int64) error {
+    query := "DELETE FROM queue_schedules WHERE id = ?"
+    _, err := db.Exec(query, id)
+    return err
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+    "encoding/json"
+    "errors"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// SettingsFile keeps settings in a JSON file in the configuration directory
+type SettingsFile struct {
+    path  string
+    mutex sync.Mutex
+}
+
+func NewSettingsFile(path string) *SettingsFile {
+    return &SettingsFile{path: path}
+}
+
+// GetSettings returns every stored setting by key, none when the file does
+// not exist yet
+func (f *SettingsFile) GetSettings() (map[string]string, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+
+    return f.read()
+}
+
+// SaveSettings stores the given settings, leaving the other keys alone. The
+// file is replaced in one step, so a crash cannot leave half of it behind.
+func (f *SettingsFile) SaveSettings(settings map[string]string) error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+
+    stored, err := f.read()
+    if err != nil {
+        return err
+    }
+    for key, value := range settings {
+        stored[key] = value
+    }
+
+    data, err := json.MarshalIndent(stored, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    temp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(temp.Name())
+
+    if _, err := temp.Write(append(data, '\n')); err != nil {
+        temp.Close()
+        return err
+    }
+    if err := temp.Sync(); err != nil {
+        temp.Close()
+        return err
+    }
+    if err := temp.Close(); err != nil {
+        return err
+    }
+
+    return os.Rename(temp.Name(), f.path)
+}
+
+func (f *SettingsFile) read() (map[string]string, error) {
+    settings := make(map[string]string)
+
+    data, err := os.ReadFile(f.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return settings, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if err := json.Unmarshal(data, &settings); err != nil {
+        return nil, err
+    }
+    return settings, nil
+}
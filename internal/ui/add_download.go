@@ -1,7 +1,9 @@
 package ui
 
 import (
+    "fmt"
     "path/filepath"
+    "strconv"
     "strings"
     "os"
     "idm-go/internal/core"
@@ -9,21 +11,28 @@ import (
     "fyne.io/fyne/v2"
     "fyne.io/fyne/v2/container"
     "fyne.io/fyne/v2/dialog"
-    "fyne.io/fyne/v2/storage"
     "fyne.io/fyne/v2/widget"
 )
 
 type AddDownloadDialog struct {
     dialog          dialog.Dialog
+    parent          fyne.Window
     urlEntry        *widget.Entry
     pathEntry       *widget.Entry
     chunksSelect    *widget.Select
+    conflictSelect  *widget.Select
+    checksumSelect  *widget.Select
+    checksumEntry   *widget.Entry
+    queueSelect     *widget.Select
+    prioritySelect  *widget.Select
+    queues          []*core.QueueInfo
     downloadManager *core.DownloadManager
     callback        func(*core.Download)
 }
 
 func NewAddDownloadDialog(parent fyne.Window, dm *core.DownloadManager, callback func(*core.Download)) *AddDownloadDialog {
     add := &AddDownloadDialog{
+        parent:          parent,
         downloadManager: dm,
         callback:        callback,
     }
@@ -61,6 +70,29 @@ func (add *AddDownloadDialog) createDialog(parent fyne.Window) {
     add.chunksSelect = widget.NewSelect([]string{"1", "2", "4", "8", "16"}, nil)
     add.chunksSelect.SetSelected("4")
 
+    // What to do when the file already exists
+    add.conflictSelect = widget.NewSelect(conflictPolicyNames(), nil)
+    add.conflictSelect.SetSelected(core.ConflictDefault.String())
+
+    // Optional expected checksum
+    add.checksumSelect = widget.NewSelect([]string{"Auto", "MD5", "SHA-1", "SHA-256", "SHA-512"}, nil)
+    add.checksumSelect.SetSelected("Auto")
+
+    add.checksumEntry = widget.NewEntry()
+    add.checksumEntry.SetPlaceHolder("Expected digest (optional)")
+
+    checksumContainer := container.NewBorder(nil, nil, add.checksumSelect, nil, add.checksumEntry)
+
+    // Queue the download is added to
+    add.queues = add.downloadManager.GetQueues()
+    add.queueSelect = widget.NewSelect(queueNames(add.queues), nil)
+    add.queueSelect.SetSelectedIndex(0)
+
+    add.prioritySelect = widget.NewSelect(priorityNames(), nil)
+    add.prioritySelect.SetSelected(core.PriorityNormal.String())
+
+    queueContainer := container.NewBorder(nil, nil, nil, add.prioritySelect, add.queueSelect)
+
     // Buttons
     addButton := widget.NewButton("Add Download", add.addDownload)
     addButton.Importance = widget.HighImportance
@@ -82,11 +114,20 @@ func (add *AddDownloadDialog) createDialog(parent fyne.Window) {
         widget.NewLabel("Number of Chunks:"),
         add.chunksSelect,
         widget.NewSeparator(),
+        widget.NewLabel("If File Exists:"),
+        add.conflictSelect,
+        widget.NewSeparator(),
+        widget.NewLabel("Checksum:"),
+        checksumContainer,
+        widget.NewSeparator(),
+        widget.NewLabel("Queue and Priority:"),
+        queueContainer,
+        widget.NewSeparator(),
         buttons,
     )
 
     add.dialog = dialog.NewCustom("Add New Download", "", form, parent)
-    add.dialog.Resize(fyne.NewSize(500, 300))
+    add.dialog.Resize(fyne.NewSize(500, 480))
 }
 
 func (add *AddDownloadDialog) addDownload() {
@@ -95,12 +136,12 @@ func (add *AddDownloadDialog) addDownload() {
 
     // Validation
     if url == "" {
-        dialog.ShowError(fmt.Errorf("URL is required"), add.dialog.(fyne.Window))
+        dialog.ShowError(fmt.Errorf("URL is required"), add.parent)
         return
     }
 
     if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "ftp://") {
-        dialog.ShowError(fmt.Errorf("Invalid URL format"), add.dialog.(fyne.Window))
+        dialog.ShowError(fmt.Errorf("Invalid URL format"), add.parent)
         return
     }
 
@@ -111,25 +152,35 @@ func (add *AddDownloadDialog) addDownload() {
     // Check if path exists
     if _, err := os.Stat(path); os.IsNotExist(err) {
         if err := os.MkdirAll(path, 0755); err != nil {
-            dialog.ShowError(fmt.Errorf("Cannot create download directory: %v", err), add.dialog.(fyne.Window))
+            dialog.ShowError(fmt.Errorf("Cannot create download directory: %v", err), add.parent)
             return
         }
     }
 
-    // Add download
-    download, err := add.downloadManager.AddDownload(url, path)
-    if err != nil {
-        dialog.ShowError(err, add.dialog.(fyne.Window))
-        return
+    options := core.DownloadOptions{
+        ConflictPolicy: conflictPolicyFromName(add.conflictSelect.Selected),
+        Checksum:       strings.TrimSpace(add.checksumEntry.Text),
+        QueueID:        queueIDFromName(add.queues, add.queueSelect.Selected),
+        Priority:       priorityFromName(add.prioritySelect.Selected),
+    }
+    if add.checksumSelect.Selected != "Auto" {
+        options.ChecksumAlgorithm = add.checksumSelect.Selected
     }
 
     // Set chunks if specified
     if chunks := add.chunksSelect.Selected; chunks != "" {
         if chunksInt, err := strconv.Atoi(chunks); err == nil {
-            download.Chunks = chunksInt
+            options.Chunks = chunksInt
         }
     }
 
+    // Add download
+    download, err := add.downloadManager.AddDownloadWithOptions(url, path, options)
+    if err != nil {
+        dialog.ShowError(err, add.parent)
+        return
+    }
+
     add.dialog.Hide()
     
     if add.callback != nil {
@@ -137,9 +188,11 @@ func (add *AddDownloadDialog) addDownload() {
     }
 
     // Show success notification
-    dialog.ShowInformation("Success", 
-        fmt.Sprintf("Download added successfully!\nFile: %s", download.Filename), 
-        add.dialog.(fyne.Window))
+    message := fmt.Sprintf("Download added successfully!\nFile: %s", download.Filename)
+    if download.Status == core.StatusCompleted {
+        message = fmt.Sprintf("File already exists with the same size, download skipped.\nFile: %s", download.Filename)
+    }
+    dialog.ShowInformation("Success", message, add.parent)
 }
 
 func (add *AddDownloadDialog) Show() {
@@ -152,4 +205,29 @@ func getDefaultDownloadPath() string {
         return "."
     }
     return filepath.Join(homeDir, "Downloads")
+}
+
+var conflictPolicies = []core.ConflictPolicy{
+    core.ConflictDefault,
+    core.ConflictRename,
+    core.ConflictOverwrite,
+    core.ConflictSkip,
+    core.ConflictResume,
+}
+
+func conflictPolicyNames() []string {
+    names := make([]string, len(conflictPolicies))
+    for i, policy := range conflictPolicies {
+        names[i] = policy.String()
+    }
+    return names
+}
+
+func conflictPolicyFromName(name string) core.ConflictPolicy {
+    for _, policy := range conflictPolicies {
+        if policy.String() == name {
+            return policy
+        }
+    }
+    return core.ConflictDefault
 }
\ No newline at end of file
@@ -0,0 +1,165 @@
+package ui
+
+import (
+    "fmt"
+    "os"
+    "strings"
+    "idm-go/internal/core"
+
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/container"
+    "fyne.io/fyne/v2/dialog"
+    "fyne.io/fyne/v2/widget"
+)
+
+// AddPackageDialog adds several downloads at once, grouped as one package
+type AddPackageDialog struct {
+    dialog          dialog.Dialog
+    parent          fyne.Window
+    nameEntry       *widget.Entry
+    urlsEntry       *widget.Entry
+    pathEntry       *widget.Entry
+    queueSelect     *widget.Select
+    prioritySelect  *widget.Select
+    queues          []*core.QueueInfo
+    downloadManager *core.DownloadManager
+    callback        func()
+}
+
+func NewAddPackageDialog(parent fyne.Window, dm *core.DownloadManager, callback func()) *AddPackageDialog {
+    add := &AddPackageDialog{
+        parent:          parent,
+        downloadManager: dm,
+        callback:        callback,
+    }
+
+    add.createDialog(parent)
+    return add
+}
+
+func (add *AddPackageDialog) createDialog(parent fyne.Window) {
+    add.nameEntry = widget.NewEntry()
+    add.nameEntry.SetPlaceHolder("Package name")
+
+    // One URL per line
+    add.urlsEntry = widget.NewMultiLineEntry()
+    add.urlsEntry.SetPlaceHolder("One download URL per line...")
+    add.urlsEntry.SetMinRowsVisible(8)
+
+    add.pathEntry = widget.NewEntry()
+    add.pathEntry.SetText(getDefaultDownloadPath())
+
+    browseButton := widget.NewButton("Browse", func() {
+        dialog.ShowFolderOpen(func(folder fyne.ListableURI, err error) {
+            if err == nil && folder != nil {
+                add.pathEntry.SetText(folder.Path())
+            }
+        }, parent)
+    })
+
+    pathContainer := container.NewBorder(nil, nil, nil, browseButton, add.pathEntry)
+
+    add.queues = add.downloadManager.GetQueues()
+    add.queueSelect = widget.NewSelect(queueNames(add.queues), nil)
+    add.queueSelect.SetSelectedIndex(0)
+
+    add.prioritySelect = widget.NewSelect(priorityNames(), nil)
+    add.prioritySelect.SetSelected(core.PriorityNormal.String())
+
+    queueContainer := container.NewBorder(nil, nil, nil, add.prioritySelect, add.queueSelect)
+
+    addButton := widget.NewButton("Add Package", add.addPackage)
+    addButton.Importance = widget.HighImportance
+
+    cancelButton := widget.NewButton("Cancel", func() {
+        add.dialog.Hide()
+    })
+
+    buttons := container.NewHBox(cancelButton, addButton)
+
+    form := container.NewVBox(
+        widget.NewLabel("Package Name:"),
+        add.nameEntry,
+        widget.NewSeparator(),
+        widget.NewLabel("Download URLs:"),
+        add.urlsEntry,
+        widget.NewSeparator(),
+        widget.NewLabel("Download Path:"),
+        pathContainer,
+        widget.NewSeparator(),
+        widget.NewLabel("Queue and Priority:"),
+        queueContainer,
+        widget.NewSeparator(),
+        buttons,
+    )
+
+    add.dialog = dialog.NewCustom("Add New Package", "", form, parent)
+    add.dialog.Resize(fyne.NewSize(600, 560))
+}
+
+func (add *AddPackageDialog) addPackage() {
+    name := strings.TrimSpace(add.nameEntry.Text)
+    path := strings.TrimSpace(add.pathEntry.Text)
+
+    var urls []string
+    for _, line := range strings.Split(add.urlsEntry.Text, "\n") {
+        url := strings.TrimSpace(line)
+        if url == "" {
+            continue
+        }
+        if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "ftp://") {
+            dialog.ShowError(fmt.Errorf("Invalid URL format: %s", url), add.parent)
+            return
+        }
+        urls = append(urls, url)
+    }
+
+    // Validation
+    if name == "" {
+        dialog.ShowError(fmt.Errorf("Package name is required"), add.parent)
+        return
+    }
+    if len(urls) == 0 {
+        dialog.ShowError(fmt.Errorf("At least one URL is required"), add.parent)
+        return
+    }
+
+    if path == "" {
+        path = getDefaultDownloadPath()
+    }
+
+    if _, err := os.Stat(path); os.IsNotExist(err) {
+        if err := os.MkdirAll(path, 0755); err != nil {
+            dialog.ShowError(fmt.Errorf("Cannot create download directory: %v", err), add.parent)
+            return
+        }
+    }
+
+    options := core.DownloadOptions{
+        QueueID:  queueIDFromName(add.queues, add.queueSelect.Selected),
+        Priority: priorityFromName(add.prioritySelect.Selected),
+    }
+
+    pkg, downloads, err := add.downloadManager.AddPackage(name, urls, path, options)
+    if pkg == nil {
+        dialog.ShowError(err, add.parent)
+        return
+    }
+
+    add.dialog.Hide()
+
+    if add.callback != nil {
+        add.callback()
+    }
+
+    // Some URLs may have failed while the others were added
+    if err != nil {
+        dialog.ShowError(fmt.Errorf("Added %d of %d downloads to %s:\n%v", len(downloads), len(urls), pkg.Name, err), add.parent)
+        return
+    }
+    dialog.ShowInformation("Success", fmt.Sprintf("Package added successfully!\n%s: %d downloads", pkg.Name, len(downloads)), add.parent)
+}
+
+func (add *AddPackageDialog) Show() {
+    add.dialog.Show()
+}
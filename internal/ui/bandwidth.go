@@ -0,0 +1,90 @@
+package ui
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "idm-go/internal/core"
+
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/dialog"
+    "fyne.io/fyne/v2/widget"
+)
+
+// BandwidthDialog edits the speed limit and weight of one download, which
+// also works while it is running
+type BandwidthDialog struct {
+    dialog          dialog.Dialog
+    parent          fyne.Window
+    download        *core.Download
+    limitEntry      *widget.Entry
+    weightEntry     *widget.Entry
+    downloadManager *core.DownloadManager
+    callback        func()
+}
+
+func NewBandwidthDialog(parent fyne.Window, dm *core.DownloadManager, download *core.Download, callback func()) *BandwidthDialog {
+    bw := &BandwidthDialog{
+        parent:          parent,
+        download:        download,
+        downloadManager: dm,
+        callback:        callback,
+    }
+
+    bw.createDialog()
+    return bw
+}
+
+func (bw *BandwidthDialog) createDialog() {
+    bw.limitEntry = widget.NewEntry()
+    bw.limitEntry.SetPlaceHolder("0 (no own limit)")
+    bw.limitEntry.SetText(strconv.FormatInt(bw.download.SpeedLimit/1024, 10))
+
+    bw.weightEntry = widget.NewEntry()
+    bw.weightEntry.SetPlaceHolder("1")
+    bw.weightEntry.SetText(strconv.Itoa(bw.download.Weight))
+
+    items := []*widget.FormItem{
+        {Text: "Speed Limit (KB/sec, 0=none):", Widget: bw.limitEntry},
+        {Text: "Bandwidth Weight:", Widget: bw.weightEntry,
+            HintText: "Share of the global limit relative to other downloads"},
+    }
+
+    bw.dialog = dialog.NewForm("Bandwidth: "+bw.download.Filename, "Apply", "Cancel", items, func(confirmed bool) {
+        if confirmed {
+            bw.apply()
+        }
+    }, bw.parent)
+    bw.dialog.Resize(fyne.NewSize(450, 250))
+}
+
+func (bw *BandwidthDialog) apply() {
+    limit, err := strconv.ParseInt(strings.TrimSpace(bw.limitEntry.Text), 10, 64)
+    if err != nil || limit < 0 {
+        dialog.ShowError(fmt.Errorf("Invalid speed limit value"), bw.parent)
+        return
+    }
+
+    weight, err := strconv.Atoi(strings.TrimSpace(bw.weightEntry.Text))
+    if err != nil || weight < 1 {
+        dialog.ShowError(fmt.Errorf("Invalid weight value"), bw.parent)
+        return
+    }
+
+    if err := bw.downloadManager.SetSpeedLimit(bw.download.ID, limit*1024); err != nil {
+        dialog.ShowError(err, bw.parent)
+        return
+    }
+    if err := bw.downloadManager.SetWeight(bw.download.ID, weight); err != nil {
+        dialog.ShowError(err, bw.parent)
+        return
+    }
+
+    if bw.callback != nil {
+        bw.callback()
+    }
+}
+
+func (bw *BandwidthDialog) Show() {
+    bw.dialog.Show()
+}
@@ -0,0 +1,102 @@
+package ui
+
+import (
+    "fmt"
+    "idm-go/internal/core"
+
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/container"
+    "fyne.io/fyne/v2/dialog"
+    "fyne.io/fyne/v2/widget"
+)
+
+// DependenciesDialog shows and edits which downloads one download waits for
+type DependenciesDialog struct {
+    dialog          dialog.Dialog
+    parent          fyne.Window
+    download        *core.Download
+    candidates      []*core.Download
+    checks          []*widget.Check
+    waitsFor        map[int64]bool
+    downloadManager *core.DownloadManager
+    callback        func()
+}
+
+func NewDependenciesDialog(parent fyne.Window, dm *core.DownloadManager, download *core.Download,
+    downloads []*core.Download, callback func()) (*DependenciesDialog, error) {
+    dependencies, err := dm.GetDependencies(download.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    dd := &DependenciesDialog{
+        parent:          parent,
+        download:        download,
+        waitsFor:        make(map[int64]bool),
+        downloadManager: dm,
+        callback:        callback,
+    }
+    for _, id := range dependencies {
+        dd.waitsFor[id] = true
+    }
+    for _, other := range downloads {
+        if other.ID != download.ID {
+            dd.candidates = append(dd.candidates, other)
+        }
+    }
+
+    dd.createDialog()
+    return dd, nil
+}
+
+func (dd *DependenciesDialog) createDialog() {
+    checks := container.NewVBox()
+    for _, candidate := range dd.candidates {
+        check := widget.NewCheck(fmt.Sprintf("%s (%s)", candidate.Filename, candidate.Status), nil)
+        check.SetChecked(dd.waitsFor[candidate.ID])
+        dd.checks = append(dd.checks, check)
+        checks.Add(check)
+    }
+
+    content := container.NewBorder(
+        widget.NewLabel("Start only after these downloads have completed:"),
+        nil, nil, nil,
+        container.NewVScroll(checks),
+    )
+
+    dd.dialog = dialog.NewCustomConfirm("Dependencies: "+dd.download.Filename, "Apply", "Cancel", content,
+        func(confirmed bool) {
+            if confirmed {
+                dd.apply()
+            }
+        }, dd.parent)
+    dd.dialog.Resize(fyne.NewSize(500, 400))
+}
+
+func (dd *DependenciesDialog) apply() {
+    for i, candidate := range dd.candidates {
+        checked := dd.checks[i].Checked
+        if checked == dd.waitsFor[candidate.ID] {
+            continue
+        }
+
+        var err error
+        if checked {
+            err = dd.downloadManager.AddDependency(dd.download.ID, candidate.ID)
+        } else {
+            err = dd.downloadManager.RemoveDependency(dd.download.ID, candidate.ID)
+        }
+        if err != nil {
+            dialog.ShowError(fmt.Errorf("%s: %v", candidate.Filename, err), dd.parent)
+            break
+        }
+    }
+
+    if dd.callback != nil {
+        dd.callback()
+    }
+}
+
+func (dd *DependenciesDialog) Show() {
+    dd.dialog.Show()
+}
@@ -2,12 +2,14 @@ package ui
 
 import (
     "fmt"
-    "path/filepath"
-    "strconv"
+    "image/color"
+    "strings"
+    "sync"
     "time"
     "idm-go/internal/core"
 
     "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/canvas"
     "fyne.io/fyne/v2/container"
     "fyne.io/fyne/v2/dialog"
     "fyne.io/fyne/v2/widget"
@@ -19,8 +21,24 @@ type MainWindow struct {
     window          fyne.Window
     downloadManager *core.DownloadManager
     downloadsList   *widget.List
+    // Events arrive on the subscription goroutine, mutex guards the fields
+    // below against the UI goroutine
+    mutex           sync.Mutex
     downloads       []*core.Download
+    packages        map[int64]*core.Package
+    expanded        map[int64]bool // packages whose downloads are shown
+    rows            []listRow
+    positions       map[int64]int // place of waiting downloads in their queue
+    selected        widget.ListItemID
     statusBar       *widget.Label
+    subscription    *core.Subscription
+}
+
+// listRow is a line of the downloads list: a package or a download
+type listRow struct {
+    pkg      *core.PackageInfo
+    download *core.Download
+    member   bool // the download is shown under its package
 }
 
 func NewMainWindow(app fyne.App, dm *core.DownloadManager) *MainWindow {
@@ -32,14 +50,19 @@ func NewMainWindow(app fyne.App, dm *core.DownloadManager) *MainWindow {
         app:             app,
         window:          window,
         downloadManager: dm,
+        selected:        -1,
+        expanded:        make(map[int64]bool),
         statusBar:       widget.NewLabel("Ready"),
     }
 
     mw.setupUI()
     mw.loadDownloads()
     
-    // Register callback for download updates
-    dm.AddCallback(mw.onDownloadUpdate)
+    // Follow the downloads until the window closes
+    mw.subscription = dm.Subscribe(mw.onEvent)
+    window.SetOnClosed(func() {
+        dm.Unsubscribe(mw.subscription)
+    })
 
     return mw
 }
@@ -70,14 +93,25 @@ func (mw *MainWindow) setupUI() {
 func (mw *MainWindow) createToolbar() *widget.Toolbar {
     toolbar := widget.NewToolbar(
         widget.NewToolbarAction(theme.ContentAddIcon(), mw.showAddDownloadDialog),
+        widget.NewToolbarAction(theme.FolderNewIcon(), mw.showAddPackageDialog),
         widget.NewToolbarSeparator(),
         widget.NewToolbarAction(theme.MediaPlayIcon(), mw.startSelectedDownload),
         widget.NewToolbarAction(theme.MediaPauseIcon(), mw.pauseSelectedDownload),
         widget.NewToolbarAction(theme.MediaStopIcon(), mw.cancelSelectedDownload),
+        widget.NewToolbarAction(theme.MediaFastForwardIcon(), mw.showBandwidthDialog),
+        widget.NewToolbarAction(theme.MailForwardIcon(), mw.moveSelectedDownload),
+        widget.NewToolbarAction(theme.MailAttachmentIcon(), mw.showDependenciesDialog),
+        widget.NewToolbarSeparator(),
+        widget.NewToolbarAction(theme.MenuDropUpIcon(), func() { mw.reorderSelectedDownload(mw.downloadManager.MoveToTop) }),
+        widget.NewToolbarAction(theme.MoveUpIcon(), func() { mw.reorderSelectedDownload(mw.downloadManager.MoveUp) }),
+        widget.NewToolbarAction(theme.MoveDownIcon(), func() { mw.reorderSelectedDownload(mw.downloadManager.MoveDown) }),
+        widget.NewToolbarAction(theme.MenuDropDownIcon(), func() { mw.reorderSelectedDownload(mw.downloadManager.MoveToBottom) }),
         widget.NewToolbarSeparator(),
         widget.NewToolbarAction(theme.DeleteIcon(), mw.deleteSelectedDownload),
         widget.NewToolbarAction(theme.ViewRefreshIcon(), mw.refreshDownloads),
         widget.NewToolbarSeparator(),
+        widget.NewToolbarAction(theme.ListIcon(), mw.showQueues),
+        widget.NewToolbarAction(theme.HistoryIcon(), mw.showScheduler),
         widget.NewToolbarAction(theme.SettingsIcon(), mw.showSettings),
     )
 
@@ -87,23 +121,50 @@ func (mw *MainWindow) createToolbar() *widget.Toolbar {
 func (mw *MainWindow) createDownloadsList() {
     mw.downloadsList = widget.NewList(
         func() int {
-            return len(mw.downloads)
+            mw.mutex.Lock()
+            defer mw.mutex.Unlock()
+            return len(mw.rows)
         },
         func() fyne.CanvasObject {
             return mw.createDownloadItem()
         },
         func(id widget.ListItemID, item fyne.CanvasObject) {
-            if id >= len(mw.downloads) {
+            mw.mutex.Lock()
+            defer mw.mutex.Unlock()
+            if id >= len(mw.rows) {
                 return
             }
-            mw.updateDownloadItem(item, mw.downloads[id])
+            row := mw.rows[id]
+            if row.pkg != nil {
+                mw.updatePackageItem(item, row.pkg)
+            } else {
+                mw.updateDownloadItem(item, row.download, row.member)
+            }
         },
     )
+    mw.downloadsList.OnSelected = func(id widget.ListItemID) {
+        mw.mutex.Lock()
+        mw.selected = id
+        mw.mutex.Unlock()
+    }
+    mw.downloadsList.OnUnselected = func(id widget.ListItemID) {
+        mw.mutex.Lock()
+        mw.selected = -1
+        mw.mutex.Unlock()
+    }
 }
 
 func (mw *MainWindow) createDownloadItem() fyne.CanvasObject {
     filename := widget.NewLabel("")
     filename.TextStyle.Bold = true
+
+    // Downloads of a package are indented under it, packages get a button
+    // to show or hide them
+    indent := canvas.NewRectangle(color.Transparent)
+    indent.SetMinSize(fyne.NewSize(theme.IconInlineSize()*2, 0))
+    toggle := widget.NewButtonWithIcon("", theme.MenuExpandIcon(), nil)
+    toggle.Importance = widget.LowImportance
+    title := container.NewBorder(nil, nil, container.NewHBox(indent, toggle), nil, filename)
     
     url := widget.NewLabel("")
     url.Truncation = fyne.TextTruncateEllipsis
@@ -116,30 +177,71 @@ func (mw *MainWindow) createDownloadItem() fyne.CanvasObject {
     speed := widget.NewLabel("")
     
     infoContainer := container.NewHBox(size, speed, status)
+
+    // The speed of every chunk, while a download fetches several at once
+    chunks := widget.NewLabel("")
+    chunks.Truncation = fyne.TextTruncateEllipsis
     
     return container.NewVBox(
-        filename,
+        title,
         url,
         progress,
         infoContainer,
+        chunks,
     )
 }
 
-func (mw *MainWindow) updateDownloadItem(item fyne.CanvasObject, download *core.Download) {
-    container := item.(*container.VBox)
-    
-    filename := container.Objects[0].(*widget.Label)
-    url := container.Objects[1].(*widget.Label)
-    progress := container.Objects[2].(*widget.ProgressBar)
-    infoContainer := container.Objects[3].(*container.HBox)
-    
-    size := infoContainer.Objects[0].(*widget.Label)
-    speed := infoContainer.Objects[1].(*widget.Label)
-    status := infoContainer.Objects[2].(*widget.Label)
-    
+// downloadItem holds the widgets of a row made by createDownloadItem
+type downloadItem struct {
+    indent   *canvas.Rectangle
+    toggle   *widget.Button
+    filename *widget.Label
+    url      *widget.Label
+    progress *widget.ProgressBar
+    size     *widget.Label
+    speed    *widget.Label
+    status   *widget.Label
+    chunks   *widget.Label
+}
+
+func itemWidgets(item fyne.CanvasObject) downloadItem {
+    row := item.(*fyne.Container)
+
+    title := row.Objects[0].(*fyne.Container)
+    left := title.Objects[1].(*fyne.Container)
+    infoContainer := row.Objects[3].(*fyne.Container)
+
+    return downloadItem{
+        indent:   left.Objects[0].(*canvas.Rectangle),
+        toggle:   left.Objects[1].(*widget.Button),
+        filename: title.Objects[0].(*widget.Label),
+        url:      row.Objects[1].(*widget.Label),
+        progress: row.Objects[2].(*widget.ProgressBar),
+        size:     infoContainer.Objects[0].(*widget.Label),
+        speed:    infoContainer.Objects[1].(*widget.Label),
+        status:   infoContainer.Objects[2].(*widget.Label),
+        chunks:   row.Objects[4].(*widget.Label),
+    }
+}
+
+func (mw *MainWindow) updateDownloadItem(item fyne.CanvasObject, download *core.Download, member bool) {
+    widgets := itemWidgets(item)
+    filename, url, progress := widgets.filename, widgets.url, widgets.progress
+    size, speed, status := widgets.size, widgets.speed, widgets.status
+
+    widgets.toggle.Hide()
+    if member {
+        widgets.indent.Show()
+    } else {
+        widgets.indent.Hide()
+    }
+
     filename.SetText(download.Filename)
     url.SetText(download.URL)
     status.SetText(download.Status.String())
+    if position, ok := mw.positions[download.ID]; ok {
+        status.SetText(fmt.Sprintf("%s #%d", download.Status, position+1))
+    }
     
     // Update progress
     progress.SetValue(download.Progress / 100.0)
@@ -155,24 +257,84 @@ func (mw *MainWindow) updateDownloadItem(item fyne.CanvasObject, download *core.
     
     // Format speed
     if download.Status == core.StatusDownloading && download.Speed > 0 {
-        speed.SetText(fmt.Sprintf("%s/s", formatBytes(download.Speed)))
+        speed.SetText(formatSpeed(download.Speed, download.ETA))
     } else {
         speed.SetText("")
     }
+
+    if text := formatChunkSpeeds(download); text != "" {
+        widgets.chunks.SetText(text)
+        widgets.chunks.Show()
+    } else {
+        widgets.chunks.Hide()
+    }
     
-    // Set status color
-    switch download.Status {
+    status.Importance = statusImportance(download.Status)
+}
+
+func (mw *MainWindow) updatePackageItem(item fyne.CanvasObject, info *core.PackageInfo) {
+    widgets := itemWidgets(item)
+
+    widgets.indent.Hide()
+    widgets.chunks.Hide()
+    widgets.toggle.Show()
+    if mw.expanded[info.ID] {
+        widgets.toggle.SetIcon(theme.MenuDropDownIcon())
+    } else {
+        widgets.toggle.SetIcon(theme.MenuExpandIcon())
+    }
+    id := info.ID
+    widgets.toggle.OnTapped = func() {
+        mw.togglePackage(id)
+    }
+
+    widgets.filename.SetText(info.Name)
+    contents := fmt.Sprintf("%d files, %d completed", len(info.Downloads), info.Completed)
+    if info.Failed > 0 {
+        contents += fmt.Sprintf(", %d failed", info.Failed)
+    }
+    widgets.url.SetText(contents)
+    widgets.progress.SetValue(info.Progress / 100.0)
+
+    if info.Size > 0 {
+        widgets.size.SetText(fmt.Sprintf("%s / %s", formatBytes(info.Downloaded), formatBytes(info.Size)))
+    } else {
+        widgets.size.SetText(formatBytes(info.Downloaded))
+    }
+
+    if info.Status == core.StatusDownloading && info.Speed > 0 {
+        widgets.speed.SetText(formatSpeed(info.Speed, info.ETA))
+    } else {
+        widgets.speed.SetText("")
+    }
+
+    widgets.status.SetText(info.Status.String())
+    widgets.status.Importance = statusImportance(info.Status)
+}
+
+func statusImportance(status core.DownloadStatus) widget.Importance {
+    switch status {
     case core.StatusCompleted:
-        status.Importance = widget.SuccessImportance
-    case core.StatusFailed:
-        status.Importance = widget.DangerImportance
-    case core.StatusDownloading:
-        status.Importance = widget.MediumImportance
+        return widget.SuccessImportance
+    case core.StatusFailed, core.StatusVerificationFailed:
+        return widget.DangerImportance
+    case core.StatusBlocked:
+        return widget.WarningImportance
     default:
-        status.Importance = widget.MediumImportance
+        return widget.MediumImportance
     }
 }
 
+// togglePackage shows or hides the downloads of a package
+func (mw *MainWindow) togglePackage(id int64) {
+    mw.downloadsList.UnselectAll()
+    mw.mutex.Lock()
+    mw.expanded[id] = !mw.expanded[id]
+    mw.buildRows()
+    mw.mutex.Unlock()
+    mw.downloadsList.Refresh()
+}
+
 func (mw *MainWindow) showAddDownloadDialog() {
     dialog := NewAddDownloadDialog(mw.window, mw.downloadManager, mw.onDownloadAdded)
     dialog.Show()
@@ -183,10 +345,41 @@ func (mw *MainWindow) onDownloadAdded(download *core.Download) {
     mw.updateStatusBar()
 }
 
+func (mw *MainWindow) showAddPackageDialog() {
+    dialog := NewAddPackageDialog(mw.window, mw.downloadManager, mw.refreshDownloads)
+    dialog.Show()
+}
+
+// selectedDownload returns the download of the selected row, nil when a
+// package or nothing is selected
+func (mw *MainWindow) selectedDownload() *core.Download {
+    mw.mutex.Lock()
+    defer mw.mutex.Unlock()
+    if mw.selected < 0 || mw.selected >= len(mw.rows) {
+        return nil
+    }
+    return mw.rows[mw.selected].download
+}
+
+// selectedPackage returns the package of the selected row, nil when a
+// download or nothing is selected
+func (mw *MainWindow) selectedPackage() *core.PackageInfo {
+    mw.mutex.Lock()
+    defer mw.mutex.Unlock()
+    if mw.selected < 0 || mw.selected >= len(mw.rows) {
+        return nil
+    }
+    return mw.rows[mw.selected].pkg
+}
+
 func (mw *MainWindow) startSelectedDownload() {
-    selected := mw.downloadsList.GetSelected()
-    if selected >= 0 && selected < len(mw.downloads) {
-        download := mw.downloads[selected]
+    if pkg := mw.selectedPackage(); pkg != nil {
+        if err := mw.downloadManager.StartPackage(pkg.ID); err != nil {
+            dialog.ShowError(err, mw.window)
+        }
+        return
+    }
+    if download := mw.selectedDownload(); download != nil {
         if err := mw.downloadManager.StartDownload(download.ID); err != nil {
             dialog.ShowError(err, mw.window)
         }
@@ -194,9 +387,13 @@ func (mw *MainWindow) startSelectedDownload() {
 }
 
 func (mw *MainWindow) pauseSelectedDownload() {
-    selected := mw.downloadsList.GetSelected()
-    if selected >= 0 && selected < len(mw.downloads) {
-        download := mw.downloads[selected]
+    if pkg := mw.selectedPackage(); pkg != nil {
+        if err := mw.downloadManager.PausePackage(pkg.ID); err != nil {
+            dialog.ShowError(err, mw.window)
+        }
+        return
+    }
+    if download := mw.selectedDownload(); download != nil {
         if err := mw.downloadManager.PauseDownload(download.ID); err != nil {
             dialog.ShowError(err, mw.window)
         }
@@ -204,10 +401,19 @@ func (mw *MainWindow) pauseSelectedDownload() {
 }
 
 func (mw *MainWindow) cancelSelectedDownload() {
-    selected := mw.downloadsList.GetSelected()
-    if selected >= 0 && selected < len(mw.downloads) {
-        download := mw.downloads[selected]
-        
+    if pkg := mw.selectedPackage(); pkg != nil {
+        dialog.ShowConfirm("Cancel Package",
+            fmt.Sprintf("Are you sure you want to cancel the %d downloads of %s?", len(pkg.Downloads), pkg.Name),
+            func(confirmed bool) {
+                if confirmed {
+                    if err := mw.downloadManager.CancelPackage(pkg.ID); err != nil {
+                        dialog.ShowError(err, mw.window)
+                    }
+                }
+            }, mw.window)
+        return
+    }
+    if download := mw.selectedDownload(); download != nil {
         dialog.ShowConfirm("Cancel Download", 
             "Are you sure you want to cancel this download?",
             func(confirmed bool) {
@@ -220,20 +426,79 @@ func (mw *MainWindow) cancelSelectedDownload() {
     }
 }
 
+func (mw *MainWindow) showBandwidthDialog() {
+    if download := mw.selectedDownload(); download != nil {
+        NewBandwidthDialog(mw.window, mw.downloadManager, download, mw.refreshDownloads).Show()
+    }
+}
+
+func (mw *MainWindow) moveSelectedDownload() {
+    download := mw.selectedDownload()
+    if download == nil {
+        return
+    }
+
+    queues := mw.downloadManager.GetQueues()
+    queueSelect := widget.NewSelect(queueNames(queues), nil)
+    queueSelect.SetSelected(queueName(queues, download.QueueID))
+
+    prioritySelect := widget.NewSelect(priorityNames(), nil)
+    prioritySelect.SetSelected(download.Priority.String())
+
+    items := []*widget.FormItem{
+        {Text: "Queue:", Widget: queueSelect},
+        {Text: "Priority:", Widget: prioritySelect},
+    }
+    dialog.ShowForm("Queue: "+download.Filename, "Apply", "Cancel", items, func(confirmed bool) {
+        if !confirmed {
+            return
+        }
+
+        err := mw.downloadManager.MoveToQueue(download.ID, queueIDFromName(queues, queueSelect.Selected))
+        if err == nil {
+            err = mw.downloadManager.SetPriority(download.ID, priorityFromName(prioritySelect.Selected))
+        }
+        if err != nil {
+            dialog.ShowError(err, mw.window)
+        }
+        mw.refreshDownloads()
+    }, mw.window)
+}
+
+func (mw *MainWindow) showDependenciesDialog() {
+    if download := mw.selectedDownload(); download != nil {
+        dependencies, err := NewDependenciesDialog(mw.window, mw.downloadManager, download, mw.currentDownloads(), mw.refreshDownloads)
+        if err != nil {
+            dialog.ShowError(err, mw.window)
+            return
+        }
+        dependencies.Show()
+    }
+}
+
+func (mw *MainWindow) reorderSelectedDownload(move func(int64) error) {
+    if download := mw.selectedDownload(); download != nil {
+        if err := move(download.ID); err != nil {
+            dialog.ShowError(err, mw.window)
+            return
+        }
+        mw.updatePositions()
+        mw.downloadsList.Refresh()
+    }
+}
+
 func (mw *MainWindow) deleteSelectedDownload() {
-    selected := mw.downloadsList.GetSelected()
-    if selected >= 0 && selected < len(mw.downloads) {
-        download := mw.downloads[selected]
+    if download := mw.selectedDownload(); download != nil {
         
         dialog.ShowConfirm("Delete Download", 
             "Are you sure you want to delete this download from the list?",
             func(confirmed bool) {
                 if confirmed {
-                    // Cancel if running
-                    if download.Status == core.StatusDownloading {
-                        mw.downloadManager.CancelDownload(download.ID)
+                    if err := mw.downloadManager.RemoveDownload(download.ID); err != nil {
+                        dialog.ShowError(err, mw.window)
+                        return
                     }
-                    mw.loadDownloads()
+                    mw.downloadsList.UnselectAll()
                 }
             }, mw.window)
     }
@@ -249,6 +514,16 @@ func (mw *MainWindow) showSettings() {
     settings.Show()
 }
 
+func (mw *MainWindow) showQueues() {
+    queues := NewQueuesWindow(mw.app, mw.downloadManager)
+    queues.Show()
+}
+
+func (mw *MainWindow) showScheduler() {
+    scheduler := NewSchedulerWindow(mw.app, mw.downloadManager)
+    scheduler.Show()
+}
+
 func (mw *MainWindow) loadDownloads() {
     downloads, err := mw.downloadManager.GetDownloads()
     if err != nil {
@@ -256,42 +531,138 @@ func (mw *MainWindow) loadDownloads() {
         return
     }
     
+    packages, err := mw.downloadManager.GetPackages()
+    if err != nil {
+        dialog.ShowError(err, mw.window)
+        return
+    }
+
+    mw.mutex.Lock()
     mw.downloads = downloads
+    mw.packages = make(map[int64]*core.Package, len(packages))
+    for _, info := range packages {
+        pkg := info.Package
+        mw.packages[pkg.ID] = &pkg
+    }
+    mw.buildRows()
+    mw.mutex.Unlock()
+    mw.updatePositions()
     mw.downloadsList.Refresh()
 }
 
+// buildRows lays out the list: a package takes the place of its newest
+// download, followed by its downloads when it is expanded. The mutex must
+// be held.
+func (mw *MainWindow) buildRows() {
+    members := make(map[int64][]*core.Download)
+    for _, download := range mw.downloads {
+        if _, ok := mw.packages[download.PackageID]; ok {
+            members[download.PackageID] = append(members[download.PackageID], download)
+        }
+    }
+
+    var rows []listRow
+    shown := make(map[int64]bool)
+    for _, download := range mw.downloads {
+        pkg, ok := mw.packages[download.PackageID]
+        if !ok {
+            rows = append(rows, listRow{download: download})
+            continue
+        }
+        if shown[pkg.ID] {
+            continue
+        }
+        shown[pkg.ID] = true
+
+        rows = append(rows, listRow{pkg: core.SummarizePackage(pkg, members[pkg.ID])})
+        if mw.expanded[pkg.ID] {
+            for _, member := range members[pkg.ID] {
+                rows = append(rows, listRow{download: member, member: true})
+            }
+        }
+    }
+    mw.rows = rows
+}
+
+// updatePositions looks up where the waiting downloads are in their queues
+func (mw *MainWindow) updatePositions() {
+    positions := make(map[int64]int)
+    for _, queue := range mw.downloadManager.GetQueues() {
+        order, err := mw.downloadManager.QueueOrder(queue.ID)
+        if err != nil {
+            continue
+        }
+        for position, id := range order {
+            positions[id] = position
+        }
+    }
+
+    mw.mutex.Lock()
+    mw.positions = positions
+    mw.mutex.Unlock()
+}
+
+// currentDownloads returns a copy of the listed downloads
+func (mw *MainWindow) currentDownloads() []*core.Download {
+    mw.mutex.Lock()
+    defer mw.mutex.Unlock()
+    return append([]*core.Download(nil), mw.downloads...)
+}
+
+func (mw *MainWindow) onEvent(event core.Event) {
+    switch event.Type {
+    case core.EventAdded, core.EventRemoved:
+        mw.refreshDownloads()
+    case core.EventPackageCompleted:
+        mw.onPackageComplete(event.Package)
+    default:
+        mw.onDownloadUpdate(event.Download)
+    }
+}
+
 func (mw *MainWindow) onDownloadUpdate(download *core.Download) {
     // Find and update the download in our list
+    mw.mutex.Lock()
     for i, d := range mw.downloads {
         if d.ID == download.ID {
             mw.downloads[i] = download
             break
         }
     }
-    
-    // Refresh the list on the main thread
+    mw.buildRows()
+    mw.mutex.Unlock()
+
+    // Widgets may be refreshed from this goroutine, only the fields under
+    // the mutex need care
+    mw.updatePositions()
     mw.downloadsList.Refresh()
     mw.updateStatusBar()
 }
 
+func (mw *MainWindow) onPackageComplete(info *core.PackageInfo) {
+    mw.app.SendNotification(fyne.NewNotification("Package complete",
+        fmt.Sprintf("All %d downloads of %s have completed", len(info.Downloads), info.Name)))
+}
+
 func (mw *MainWindow) updateStatusBar() {
     downloading := 0
     completed := 0
     failed := 0
     
-    for _, download := range mw.downloads {
+    downloads := mw.currentDownloads()
+    for _, download := range downloads {
         switch download.Status {
         case core.StatusDownloading:
             downloading++
         case core.StatusCompleted:
             completed++
-        case core.StatusFailed:
+        case core.StatusFailed, core.StatusVerificationFailed, core.StatusBlocked:
             failed++
         }
     }
     
-    status := fmt.Sprintf("Downloads: %d | Active: %d | Completed: %d | Failed: %d", 
-        len(mw.downloads), downloading, completed, failed)
+    status := fmt.Sprintf("Downloads: %d | Active: %d | Completed: %d | Failed: %d | Speed: %s/s",
+        len(downloads), downloading, completed, failed, formatBytes(mw.downloadManager.Speed()))
     mw.statusBar.SetText(status)
 }
 
@@ -310,4 +681,52 @@ func formatBytes(bytes int64) string {
         exp++
     }
     return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
\ No newline at end of file
+}
+
+// formatSpeed shows a speed with the time left, when known
+func formatSpeed(speed int64, eta time.Duration) string {
+    text := fmt.Sprintf("%s/s", formatBytes(speed))
+    if eta > 0 {
+        text += ", " + formatDuration(eta) + " left"
+    }
+    return text
+}
+
+// formatChunkSpeeds lists the speed of every chunk of a running download,
+// empty when it has fewer than two
+func formatChunkSpeeds(download *core.Download) string {
+    if download.Status != core.StatusDownloading || len(download.Segments) < 2 {
+        return ""
+    }
+
+    speeds := make([]string, 0, len(download.Segments))
+    for _, chunk := range download.Segments {
+        switch {
+        case chunk.Downloaded >= chunk.End-chunk.Start+1:
+            speeds = append(speeds, "done")
+        case chunk.Speed > 0:
+            speeds = append(speeds, formatBytes(chunk.Speed)+"/s")
+        default:
+            speeds = append(speeds, "idle")
+        }
+    }
+    return "Chunks: " + strings.Join(speeds, " | ")
+}
+
+// formatDuration shows a remaining time to the second, without the zero
+// leading units
+func formatDuration(d time.Duration) string {
+    d = d.Round(time.Second)
+    hours := int(d / time.Hour)
+    minutes := int(d % time.Hour / time.Minute)
+    seconds := int(d % time.Minute / time.Second)
+
+    switch {
+    case hours > 0:
+        return fmt.Sprintf("%dh%02dm", hours, minutes)
+    case minutes > 0:
+        return fmt.Sprintf("%dm%02ds", minutes, seconds)
+    default:
+        return fmt.Sprintf("%ds", seconds)
+    }
+}
@@ -0,0 +1,267 @@
+package ui
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "idm-go/internal/core"
+
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/container"
+    "fyne.io/fyne/v2/dialog"
+    "fyne.io/fyne/v2/widget"
+)
+
+// QueuesWindow lists the download queues and edits their limits
+type QueuesWindow struct {
+    window             fyne.Window
+    downloadManager    *core.DownloadManager
+    queues             []*core.QueueInfo
+    selected           widget.ListItemID
+    queuesList         *widget.List
+    nameEntry          *widget.Entry
+    maxConcurrentEntry *widget.Entry
+    maxSpeedEntry      *widget.Entry
+}
+
+func NewQueuesWindow(app fyne.App, dm *core.DownloadManager) *QueuesWindow {
+    window := app.NewWindow("Queues")
+    window.Resize(fyne.NewSize(500, 450))
+
+    qw := &QueuesWindow{
+        window:          window,
+        downloadManager: dm,
+        selected:        -1,
+    }
+
+    qw.setupUI()
+    qw.loadQueues()
+
+    return qw
+}
+
+func (qw *QueuesWindow) setupUI() {
+    qw.queuesList = widget.NewList(
+        func() int {
+            return len(qw.queues)
+        },
+        func() fyne.CanvasObject {
+            return widget.NewLabel("")
+        },
+        func(id widget.ListItemID, item fyne.CanvasObject) {
+            if id >= len(qw.queues) {
+                return
+            }
+            item.(*widget.Label).SetText(describeQueue(qw.queues[id]))
+        },
+    )
+    qw.queuesList.OnSelected = func(id widget.ListItemID) {
+        qw.selected = id
+        queue := qw.queues[id]
+        qw.nameEntry.SetText(queue.Name)
+        qw.maxConcurrentEntry.SetText(strconv.Itoa(queue.MaxConcurrent))
+        qw.maxSpeedEntry.SetText(strconv.FormatInt(queue.MaxSpeed/1024, 10))
+    }
+    qw.queuesList.OnUnselected = func(id widget.ListItemID) {
+        qw.selected = -1
+    }
+
+    qw.nameEntry = widget.NewEntry()
+    qw.nameEntry.SetPlaceHolder("Nightly")
+
+    qw.maxConcurrentEntry = widget.NewEntry()
+    qw.maxConcurrentEntry.SetPlaceHolder("3")
+
+    qw.maxSpeedEntry = widget.NewEntry()
+    qw.maxSpeedEntry.SetPlaceHolder("0 (unlimited)")
+
+    form := widget.NewForm(
+        widget.NewFormItem("Name:", qw.nameEntry),
+        widget.NewFormItem("Max Concurrent Downloads:", qw.maxConcurrentEntry),
+        widget.NewFormItem("Max Speed (KB/sec, 0=unlimited):", qw.maxSpeedEntry),
+    )
+
+    addButton := widget.NewButton("Add Queue", qw.addQueue)
+    addButton.Importance = widget.HighImportance
+    saveButton := widget.NewButton("Save Selected", qw.saveSelectedQueue)
+    deleteButton := widget.NewButton("Delete Selected", qw.deleteSelectedQueue)
+
+    startButton := widget.NewButton("Start", func() {
+        qw.withSelectedQueue(qw.downloadManager.StartQueue)
+    })
+    stopButton := widget.NewButton("Stop", func() {
+        qw.withSelectedQueue(qw.downloadManager.StopQueue)
+    })
+
+    top := container.NewVBox(
+        widget.NewLabel("Download Queues"),
+        container.NewHBox(startButton, stopButton),
+        widget.NewSeparator(),
+    )
+    bottom := container.NewVBox(
+        widget.NewSeparator(),
+        form,
+        container.NewHBox(deleteButton, saveButton, addButton),
+    )
+
+    qw.window.SetContent(container.NewBorder(top, bottom, nil, nil, qw.queuesList))
+}
+
+func (qw *QueuesWindow) loadQueues() {
+    qw.queues = qw.downloadManager.GetQueues()
+    qw.queuesList.UnselectAll()
+    qw.queuesList.Refresh()
+}
+
+// readForm returns the queue settings entered in the form
+func (qw *QueuesWindow) readForm() (*core.QueueInfo, error) {
+    maxConcurrent, err := strconv.Atoi(strings.TrimSpace(qw.maxConcurrentEntry.Text))
+    if err != nil || maxConcurrent < 1 || maxConcurrent > 20 {
+        return nil, fmt.Errorf("Max downloads must be between 1 and 20")
+    }
+
+    maxSpeed, err := strconv.ParseInt(strings.TrimSpace(qw.maxSpeedEntry.Text), 10, 64)
+    if err != nil || maxSpeed < 0 {
+        return nil, fmt.Errorf("Max speed must be a positive number or 0")
+    }
+
+    return &core.QueueInfo{
+        Name:          qw.nameEntry.Text,
+        MaxConcurrent: maxConcurrent,
+        MaxSpeed:      maxSpeed * 1024,
+        Running:       true,
+    }, nil
+}
+
+func (qw *QueuesWindow) addQueue() {
+    info, err := qw.readForm()
+    if err == nil {
+        err = qw.downloadManager.CreateQueue(info)
+    }
+    if err != nil {
+        dialog.ShowError(err, qw.window)
+        return
+    }
+
+    qw.loadQueues()
+}
+
+func (qw *QueuesWindow) saveSelectedQueue() {
+    selected := qw.selected
+    if selected < 0 || selected >= len(qw.queues) {
+        return
+    }
+
+    info, err := qw.readForm()
+    if err == nil {
+        info.ID = qw.queues[selected].ID
+        err = qw.downloadManager.UpdateQueue(info)
+    }
+    if err != nil {
+        dialog.ShowError(err, qw.window)
+        return
+    }
+
+    qw.loadQueues()
+}
+
+func (qw *QueuesWindow) deleteSelectedQueue() {
+    selected := qw.selected
+    if selected < 0 || selected >= len(qw.queues) {
+        return
+    }
+    queue := qw.queues[selected]
+
+    dialog.ShowConfirm("Delete Queue",
+        fmt.Sprintf("Delete the queue %q? Its downloads move to the main queue.", queue.Name),
+        func(confirmed bool) {
+            if confirmed {
+                qw.withQueue(queue.ID, qw.downloadManager.DeleteQueue)
+            }
+        }, qw.window)
+}
+
+func (qw *QueuesWindow) withSelectedQueue(action func(int64) error) {
+    selected := qw.selected
+    if selected >= 0 && selected < len(qw.queues) {
+        qw.withQueue(qw.queues[selected].ID, action)
+    }
+}
+
+func (qw *QueuesWindow) withQueue(queueID int64, action func(int64) error) {
+    if err := action(queueID); err != nil {
+        dialog.ShowError(err, qw.window)
+        return
+    }
+    qw.loadQueues()
+}
+
+func (qw *QueuesWindow) Show() {
+    qw.window.Show()
+}
+
+func describeQueue(queue *core.QueueInfo) string {
+    state := "running"
+    if !queue.Running {
+        state = "stopped"
+    }
+
+    speed := "unlimited"
+    if queue.MaxSpeed > 0 {
+        speed = formatBytes(queue.MaxSpeed) + "/s"
+    }
+
+    return fmt.Sprintf("%s  (%s, %d at a time, %s)", queue.Name, state, queue.MaxConcurrent, speed)
+}
+
+// queueNames returns the names of the queues for a select, in their order
+func queueNames(queues []*core.QueueInfo) []string {
+    names := make([]string, len(queues))
+    for i, queue := range queues {
+        names[i] = queue.Name
+    }
+    return names
+}
+
+// queueIDFromName returns the ID of the named queue, 0 when there is none
+func queueIDFromName(queues []*core.QueueInfo, name string) int64 {
+    for _, queue := range queues {
+        if queue.Name == name {
+            return queue.ID
+        }
+    }
+    return 0
+}
+
+// queueName returns the name of the queue with the given ID
+func queueName(queues []*core.QueueInfo, id int64) string {
+    for _, queue := range queues {
+        if queue.ID == id {
+            return queue.Name
+        }
+    }
+    return ""
+}
+
+var priorities = []core.Priority{
+    core.PriorityHigh,
+    core.PriorityNormal,
+    core.PriorityLow,
+}
+
+func priorityNames() []string {
+    names := make([]string, len(priorities))
+    for i, priority := range priorities {
+        names[i] = priority.String()
+    }
+    return names
+}
+
+func priorityFromName(name string) core.Priority {
+    for _, priority := range priorities {
+        if priority.String() == name {
+            return priority
+        }
+    }
+    return core.PriorityNormal
+}
@@ -0,0 +1,130 @@
+package ui
+
+import (
+    "fmt"
+    "time"
+    "idm-go/internal/core"
+
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/container"
+    "fyne.io/fyne/v2/widget"
+)
+
+// scheduleDays lists the grid rows, Monday first
+var scheduleDays = []time.Weekday{
+    time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// scheduleGrid edits a weekly bandwidth schedule as one toggle per day and
+// hour. Every marked hour is limited to the same rate.
+type scheduleGrid struct {
+    cells   [7][24]bool
+    buttons [7][24]*widget.Button
+    loaded  *core.BandwidthSchedule // as passed to setSchedule
+    shown   int64                   // the limit of loaded, rounded to KB like the form shows it
+    edited  bool                    // a cell was toggled since setSchedule
+}
+
+func newScheduleGrid() *scheduleGrid {
+    return &scheduleGrid{}
+}
+
+func (g *scheduleGrid) build() fyne.CanvasObject {
+    grid := container.NewGridWithColumns(25)
+
+    grid.Add(widget.NewLabel(""))
+    for hour := 0; hour < 24; hour++ {
+        grid.Add(widget.NewLabel(fmt.Sprintf("%02d", hour)))
+    }
+
+    for row, day := range scheduleDays {
+        grid.Add(widget.NewLabel(day.String()[:3]))
+        for hour := 0; hour < 24; hour++ {
+            row, hour := row, hour
+            button := widget.NewButton("", func() {
+                g.cells[row][hour] = !g.cells[row][hour]
+                g.edited = true
+                g.refreshCell(row, hour)
+            })
+            g.buttons[row][hour] = button
+            g.refreshCell(row, hour)
+            grid.Add(button)
+        }
+    }
+
+    return grid
+}
+
+func (g *scheduleGrid) refreshCell(row, hour int) {
+    button := g.buttons[row][hour]
+    if button == nil {
+        return
+    }
+
+    if g.cells[row][hour] {
+        button.Importance = widget.HighImportance
+    } else {
+        button.Importance = widget.LowImportance
+    }
+    button.Refresh()
+}
+
+// setSchedule marks the hours covered by a window of the schedule and
+// returns the limit of the first window
+func (g *scheduleGrid) setSchedule(schedule *core.BandwidthSchedule) int64 {
+    var limit int64
+    if schedule != nil && len(schedule.Windows) > 0 {
+        limit = schedule.Windows[0].Limit
+    }
+    g.loaded = schedule
+    g.shown = limit / 1024 * 1024
+    g.edited = false
+
+    // Any week will do, 2024-01-01 was a Monday
+    for row := range scheduleDays {
+        for hour := 0; hour < 24; hour++ {
+            at := time.Date(2024, 1, 1+row, hour, 30, 0, 0, time.Local)
+            g.cells[row][hour] = schedule.LimitAt(at, -1) != -1
+            g.refreshCell(row, hour)
+        }
+    }
+
+    return limit
+}
+
+// schedule turns the marked hours into windows limited to limit, nil when
+// no hour is marked. Without changes it returns the schedule that was set,
+// which may have finer windows and other limits than the grid can show.
+func (g *scheduleGrid) schedule(limit int64) *core.BandwidthSchedule {
+    if !g.edited && limit == g.shown {
+        return g.loaded
+    }
+
+    var windows []core.BandwidthWindow
+    for row, day := range scheduleDays {
+        for hour := 0; hour < 24; {
+            if !g.cells[row][hour] {
+                hour++
+                continue
+            }
+
+            start := hour
+            for hour < 24 && g.cells[row][hour] {
+                hour++
+            }
+
+            // An end at midnight is written as 0, which runs to the end of the day
+            windows = append(windows, core.BandwidthWindow{
+                Days:  []time.Weekday{day},
+                Start: time.Duration(start) * time.Hour,
+                End:   time.Duration(hour%24) * time.Hour,
+                Limit: limit,
+            })
+        }
+    }
+
+    if len(windows) == 0 {
+        return nil
+    }
+    return &core.BandwidthSchedule{Windows: windows}
+}
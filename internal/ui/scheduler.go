@@ -0,0 +1,193 @@
+package ui
+
+import (
+    "fmt"
+    "strings"
+    "time"
+    "idm-go/internal/core"
+
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/container"
+    "fyne.io/fyne/v2/dialog"
+    "fyne.io/fyne/v2/widget"
+)
+
+// SchedulerWindow lists the queue schedules and adds or removes them
+type SchedulerWindow struct {
+    window          fyne.Window
+    downloadManager *core.DownloadManager
+    schedules       []*core.QueueSchedule
+    selected        widget.ListItemID
+    schedulesList   *widget.List
+    queues          []*core.QueueInfo
+    queueSelect     *widget.Select
+    dayChecks       []*widget.Check
+    startEntry      *widget.Entry
+    stopEntry       *widget.Entry
+}
+
+func NewSchedulerWindow(app fyne.App, dm *core.DownloadManager) *SchedulerWindow {
+    window := app.NewWindow("Scheduler")
+    window.Resize(fyne.NewSize(500, 450))
+
+    sw := &SchedulerWindow{
+        window:          window,
+        downloadManager: dm,
+        selected:        -1,
+    }
+
+    sw.setupUI()
+    sw.loadSchedules()
+
+    return sw
+}
+
+func (sw *SchedulerWindow) setupUI() {
+    sw.schedulesList = widget.NewList(
+        func() int {
+            return len(sw.schedules)
+        },
+        func() fyne.CanvasObject {
+            return widget.NewLabel("")
+        },
+        func(id widget.ListItemID, item fyne.CanvasObject) {
+            if id >= len(sw.schedules) {
+                return
+            }
+            schedule := sw.schedules[id]
+            item.(*widget.Label).SetText(queueName(sw.queues, schedule.QueueID) + ": " + describeSchedule(schedule))
+        },
+    )
+    sw.schedulesList.OnSelected = func(id widget.ListItemID) {
+        sw.selected = id
+    }
+    sw.schedulesList.OnUnselected = func(id widget.ListItemID) {
+        sw.selected = -1
+    }
+
+    sw.queues = sw.downloadManager.GetQueues()
+    sw.queueSelect = widget.NewSelect(queueNames(sw.queues), nil)
+    sw.queueSelect.SetSelectedIndex(0)
+
+    days := container.NewHBox()
+    for _, day := range scheduleDays {
+        check := widget.NewCheck(day.String()[:3], nil)
+        sw.dayChecks = append(sw.dayChecks, check)
+        days.Add(check)
+    }
+
+    sw.startEntry = widget.NewEntry()
+    sw.startEntry.SetPlaceHolder("01:00")
+
+    sw.stopEntry = widget.NewEntry()
+    sw.stopEntry.SetPlaceHolder("07:00")
+
+    form := widget.NewForm(
+        widget.NewFormItem("Queue:", sw.queueSelect),
+        widget.NewFormItem("Days:", days),
+        widget.NewFormItem("Start Queue At:", sw.startEntry),
+        widget.NewFormItem("Stop Queue At:", sw.stopEntry),
+    )
+
+    addButton := widget.NewButton("Add Schedule", sw.addSchedule)
+    addButton.Importance = widget.HighImportance
+    deleteButton := widget.NewButton("Delete Selected", sw.deleteSelectedSchedule)
+
+    top := container.NewVBox(
+        widget.NewLabel("Queue Schedules"),
+        widget.NewSeparator(),
+    )
+    bottom := container.NewVBox(
+        widget.NewSeparator(),
+        form,
+        container.NewHBox(deleteButton, addButton),
+    )
+
+    sw.window.SetContent(container.NewBorder(top, bottom, nil, nil, sw.schedulesList))
+}
+
+func (sw *SchedulerWindow) loadSchedules() {
+    schedules, err := sw.downloadManager.GetQueueSchedules()
+    if err != nil {
+        dialog.ShowError(err, sw.window)
+        return
+    }
+
+    sw.schedules = schedules
+    sw.schedulesList.UnselectAll()
+    sw.schedulesList.Refresh()
+}
+
+func (sw *SchedulerWindow) addSchedule() {
+    start, err := parseTimeOfDay(sw.startEntry.Text)
+    if err != nil {
+        dialog.ShowError(fmt.Errorf("Start time must look like 01:00"), sw.window)
+        return
+    }
+
+    stop, err := parseTimeOfDay(sw.stopEntry.Text)
+    if err != nil {
+        dialog.ShowError(fmt.Errorf("Stop time must look like 07:00"), sw.window)
+        return
+    }
+
+    schedule := &core.QueueSchedule{
+        QueueID:   queueIDFromName(sw.queues, sw.queueSelect.Selected),
+        StartTime: start,
+        StopTime:  stop,
+        Enabled:   true,
+    }
+    for i, day := range scheduleDays {
+        schedule.SetDay(day, sw.dayChecks[i].Checked)
+    }
+
+    if err := sw.downloadManager.SaveQueueSchedule(schedule); err != nil {
+        dialog.ShowError(err, sw.window)
+        return
+    }
+
+    sw.loadSchedules()
+}
+
+func (sw *SchedulerWindow) deleteSelectedSchedule() {
+    selected := sw.selected
+    if selected < 0 || selected >= len(sw.schedules) {
+        return
+    }
+
+    if err := sw.downloadManager.DeleteQueueSchedule(sw.schedules[selected].ID); err != nil {
+        dialog.ShowError(err, sw.window)
+        return
+    }
+
+    sw.loadSchedules()
+}
+
+func (sw *SchedulerWindow) Show() {
+    sw.window.Show()
+}
+
+// parseTimeOfDay turns "HH:MM" into minutes since midnight
+func parseTimeOfDay(text string) (int, error) {
+    t, err := time.Parse("15:04", strings.TrimSpace(text))
+    if err != nil {
+        return 0, err
+    }
+    return t.Hour()*60 + t.Minute(), nil
+}
+
+func describeSchedule(schedule *core.QueueSchedule) string {
+    var days []string
+    for _, day := range scheduleDays {
+        if schedule.HasDay(day) {
+            days = append(days, day.String()[:3])
+        }
+    }
+
+    text := fmt.Sprintf("%s  %02d:%02d - %02d:%02d", strings.Join(days, " "),
+        schedule.StartTime/60, schedule.StartTime%60, schedule.StopTime/60, schedule.StopTime%60)
+    if !schedule.Enabled {
+        text += "  (disabled)"
+    }
+    return text
+}
@@ -1,7 +1,10 @@
 package ui
 
 import (
+    "fmt"
     "strconv"
+    "strings"
+    "time"
     "idm-go/internal/core"
 
     "fyne.io/fyne/v2"
@@ -22,11 +25,16 @@ type SettingsWindow struct {
     retryAttemptsEntry  *widget.Entry
     userAgentEntry      *widget.Entry
     timeoutEntry        *widget.Entry
+    conflictSelect      *widget.Select
+    discoverCheck       *widget.Check
+    autoResumeCheck     *widget.Check
+    scheduleLimitEntry  *widget.Entry
+    scheduleGrid        *scheduleGrid
 }
 
 func NewSettingsWindow(app fyne.App, dm *core.DownloadManager) *SettingsWindow {
     window := app.NewWindow("Settings")
-    window.Resize(fyne.NewSize(500, 400))
+    window.Resize(fyne.NewSize(900, 600))
 
     settings := &SettingsWindow{
         app:             app,
@@ -60,15 +68,34 @@ func (sw *SettingsWindow) setupUI() {
     sw.timeoutEntry = widget.NewEntry()
     sw.timeoutEntry.SetPlaceHolder("30")
 
+    // The per-download "Default" choice refers to this setting, so it is not offered here
+    sw.conflictSelect = widget.NewSelect(conflictPolicyNames()[1:], nil)
+
+    sw.discoverCheck = widget.NewCheck("Look for .sha256 / SHA256SUMS files", nil)
+
+    sw.autoResumeCheck = widget.NewCheck("Resume interrupted downloads on startup", nil)
+
+    // Hours marked in the grid use the schedule limit instead of Max Speed
+    sw.scheduleLimitEntry = widget.NewEntry()
+    sw.scheduleLimitEntry.SetPlaceHolder("0 (unlimited)")
+    sw.scheduleGrid = newScheduleGrid()
+
+    scheduleForm := widget.NewForm(
+        widget.NewFormItem("Scheduled Speed (KB/sec, 0=unlimited):", sw.scheduleLimitEntry),
+    )
+
     // Create form
     form := &widget.Form{
         Items: []*widget.FormItem{
             {Text: "Max Concurrent Downloads:", Widget: sw.maxDownloadsEntry},
-            {Text: "Max Speed (bytes/sec, 0=unlimited):", Widget: sw.maxSpeedEntry},
+            {Text: "Max Speed (KB/sec, 0=unlimited):", Widget: sw.maxSpeedEntry},
             {Text: "Chunk Size (bytes):", Widget: sw.chunkSizeEntry},
             {Text: "Retry Attempts:", Widget: sw.retryAttemptsEntry},
             {Text: "User Agent:", Widget: sw.userAgentEntry},
             {Text: "Timeout (seconds):", Widget: sw.timeoutEntry},
+            {Text: "If File Exists:", Widget: sw.conflictSelect},
+            {Text: "Checksums:", Widget: sw.discoverCheck},
+            {Text: "Startup:", Widget: sw.autoResumeCheck},
         },
         OnSubmit:   sw.saveSettings,
         OnCancel:   func() { sw.window.Hide() },
@@ -89,6 +116,10 @@ func (sw *SettingsWindow) setupUI() {
         widget.NewSeparator(),
         form,
         widget.NewSeparator(),
+        widget.NewLabel("Bandwidth Schedule (marked hours use the scheduled speed):"),
+        scheduleForm,
+        sw.scheduleGrid.build(),
+        widget.NewSeparator(),
         buttonsContainer,
     )
 
@@ -96,16 +127,23 @@ func (sw *SettingsWindow) setupUI() {
 }
 
 func (sw *SettingsWindow) loadSettings() {
-    // In a real implementation, you would load these from the DownloadManager
-    // For now, we'll use default values
-    config := core.DefaultConfig()
+    sw.showConfig(sw.downloadManager.Config())
+}
 
+// showConfig fills the form; nothing changes until it is saved
+func (sw *SettingsWindow) showConfig(config core.DownloadConfig) {
     sw.maxDownloadsEntry.SetText(strconv.Itoa(config.MaxConcurrentDownloads))
-    sw.maxSpeedEntry.SetText(strconv.FormatInt(config.MaxSpeed, 10))
+    sw.maxSpeedEntry.SetText(strconv.FormatInt(config.MaxSpeed/1024, 10))
     sw.chunkSizeEntry.SetText(strconv.FormatInt(config.ChunkSize, 10))
     sw.retryAttemptsEntry.SetText(strconv.Itoa(config.RetryAttempts))
     sw.userAgentEntry.SetText(config.UserAgent)
     sw.timeoutEntry.SetText(strconv.FormatInt(int64(config.Timeout.Seconds()), 10))
+    sw.conflictSelect.SetSelected(config.ConflictPolicy.String())
+    sw.discoverCheck.SetChecked(config.DiscoverChecksums)
+    sw.autoResumeCheck.SetChecked(config.AutoResume)
+
+    limit := sw.scheduleGrid.setSchedule(config.BandwidthSchedule)
+    sw.scheduleLimitEntry.SetText(strconv.FormatInt(limit/1024, 10))
 }
 
 func (sw *SettingsWindow) saveSettings() {
@@ -145,7 +183,34 @@ func (sw *SettingsWindow) saveSettings() {
         userAgent = "IDM-Go/1.0"
     }
 
-    // Apply settings (in a real implementation, you would save these to the DownloadManager)
+    scheduleLimit, err := strconv.ParseInt(sw.scheduleLimitEntry.Text, 10, 64)
+    if err != nil || scheduleLimit < 0 {
+        dialog.ShowError(fmt.Errorf("Scheduled speed must be a positive number or 0"), sw.window)
+        return
+    }
+
+    // Settings not on the form keep their current values
+    config := sw.downloadManager.Config()
+    config.MaxConcurrentDownloads = maxDownloads
+    // A limit set in bytes elsewhere is kept when the rounded value shown
+    // was left alone
+    if maxSpeed != config.MaxSpeed/1024 {
+        config.MaxSpeed = maxSpeed * 1024
+    }
+    config.ChunkSize = chunkSize
+    config.RetryAttempts = retryAttempts
+    config.UserAgent = userAgent
+    config.Timeout = time.Duration(timeout) * time.Second
+    config.ConflictPolicy = conflictPolicyFromName(sw.conflictSelect.Selected)
+    config.DiscoverChecksums = sw.discoverCheck.Checked
+    config.AutoResume = sw.autoResumeCheck.Checked
+    config.BandwidthSchedule = sw.scheduleGrid.schedule(scheduleLimit * 1024)
+
+    if err := sw.downloadManager.UpdateConfig(config); err != nil {
+        dialog.ShowError(fmt.Errorf("Failed to save settings: %v", err), sw.window)
+        return
+    }
+
     dialog.ShowInformation("Settings Saved", "Settings have been saved successfully!", sw.window)
     sw.window.Hide()
 }
@@ -155,7 +220,7 @@ func (sw *SettingsWindow) resetToDefaults() {
         "Are you sure you want to reset all settings to default values?",
         func(confirmed bool) {
             if confirmed {
-                sw.loadSettings()
+                sw.showConfig(*core.DefaultConfig())
             }
         }, sw.window)
 }
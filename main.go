@@ -1,28 +1,72 @@
 package main
 
 import (
+    "context"
+    "errors"
+    "flag"
     "idm-go/internal/core"
     "idm-go/internal/storage"
     "idm-go/internal/ui"
     "log"
+    "time"
 
     "fyne.io/fyne/v2/app"
 )
 
+// shutdownTimeout bounds how long running downloads get to save their state
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+    dataDir := flag.String("data-dir", "", "directory for the database, instead of $"+storage.DataDirEnv+" or $XDG_DATA_HOME/idm-go")
+    flag.Parse()
+
     myApp := app.NewWithID("com.example.idm")
+
+    location, err := storage.ResolveLocation(*dataDir)
+    if err != nil {
+        log.Fatal("Failed to find the data directory:", err)
+    }
+
+    // One instance per data directory
+    lock, err := location.Lock()
+    if errors.Is(err, storage.ErrLocked) {
+        log.Fatalf("Another instance is already running with %s", location.DataDir)
+    }
+    if err != nil {
+        log.Fatal("Failed to lock the data directory:", err)
+    }
+    defer lock.Release()
+
+    if moved, err := location.MigrateLegacyDatabase(); err != nil {
+        log.Fatal("Failed to move the database to the data directory:", err)
+    } else if moved {
+        log.Println("Moved idm.db to", location.DataDir)
+    }
     
     // Initialize database
-    db, err := storage.InitDB()
+    store, err := storage.OpenSQLite(location.DatabasePath(), location.SettingsPath())
     if err != nil {
         log.Fatal("Failed to initialize database:", err)
     }
-    defer db.Close()
+    defer store.Close()
 
     // Initialize download manager
-    downloadManager := core.NewDownloadManager(db)
+    downloadManager, err := core.NewDownloadManager(store)
+    if err != nil {
+        log.Fatal("Failed to initialize download manager:", err)
+    }
+    if err := downloadManager.Start(context.Background()); err != nil {
+        log.Fatal("Failed to start download manager:", err)
+    }
     
     // Create main window
     mainWindow := ui.NewMainWindow(myApp, downloadManager)
     mainWindow.ShowAndRun()
-}
\ No newline at end of file
+
+    // Let the downloads save their state before the database is closed
+    ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+    if err := downloadManager.Shutdown(ctx); err != nil {
+        log.Println("Failed to shut down download manager:", err)
+    }
+}